@@ -1,92 +1,300 @@
 package nyne
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
+	"runtime"
 	"sync"
-
-	"9fans.net/go/acme"
+	"time"
 )
 
 // Acme implements the Listener interface for acme events
 type Acme struct {
+	// Open opens the windows of started buffers
+	Open       WinOpener
+	LogHooks   map[Text][]LogHandler
 	EventHooks map[Text][]Handler
 	WinHooks   map[Text][]WinHandler
 	KeyHooks   map[rune]Handler
-	wins       map[int]string
-	bufs       map[int]*Buf
-	mux        sync.Mutex
+	// Router runs hooks in every Buf after the hooks in the maps
+	Router *Router
+	// CloseHooks run after a window's Buf has stopped
+	CloseHooks []BufHandler
+	// Errors receives the errors of Bufs that stop early and of
+	// hooks that time out
+	Errors ErrorHandler
+	// Shadow keeps a copy of each Buf's body in memory
+	Shadow bool
+	// Pool bounds the write hooks running at once across every Buf
+	Pool *Pool
+	// HookTimeout is how long each write hook and window hook may run
+	HookTimeout time.Duration
+	// Trace receives the events of every Buf and the results of
+	// the hooks run on them
+	Trace Tracer
+	// Kinds are the kinds of window a Buf is started for
+	Kinds []WinKind
+	// Reconnect is how long to wait between attempts to reconnect
+	// when acme exits. Listen returns instead if it is nil.
+	Reconnect *Backoff
+	// ConnectHooks run each time Listen connects to acme, before it
+	// attaches to the windows. Handles opened on an acme that has
+	// exited can no longer be used, so hooks that keep windows open
+	// across sessions open them again here.
+	ConnectHooks []func() error
+	wins         map[int]WinInfo
+	bufs         *Registry
+	mux          sync.Mutex
 }
 
 // NewAcme constructs an Acme event listener
 func NewAcme() *Acme {
 	return &Acme{
-		EventHooks: make(map[Text][]Handler),
-		WinHooks:   make(map[Text][]WinHandler),
-		KeyHooks:   make(map[rune]Handler),
-		wins:       make(map[int]string),
-		bufs:       make(map[int]*Buf),
+		Open:        OpenWindow,
+		LogHooks:    make(map[Text][]LogHandler),
+		EventHooks:  make(map[Text][]Handler),
+		WinHooks:    make(map[Text][]WinHandler),
+		KeyHooks:    make(map[rune]Handler),
+		Router:      NewRouter(),
+		Errors:      StderrErrors,
+		Pool:        NewPool(runtime.NumCPU()),
+		HookTimeout: DefaultHookTimeout,
+		Kinds:       []WinKind{WinFile, WinGuide},
+		wins:        make(map[int]WinInfo),
+		bufs:        NewRegistry(),
 	}
 }
 
 // Listen watches the acme event log for events and executes hooks
-// based on those events
+// based on those events. LogHooks are run in the listening goroutine
+// and should not block.
 func (a *Acme) Listen() error {
-	l, err := acme.Log()
+	return a.ListenContext(context.Background())
+}
+
+// ListenContext is like Listen but stops when ctx is done. Every
+// running Buf is stopped and its window files closed before
+// ListenContext returns ctx.Err().
+//
+// If Reconnect is set and the connection to acme is lost,
+// ListenContext waits for acme to come back and attaches to the new
+// acme's windows instead of returning. Any other error, such as one
+// returned by a ConnectHook, is returned.
+func (a *Acme) ListenContext(ctx context.Context) error {
+	for {
+		err := a.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var lost *lostError
+		if a.Reconnect == nil || !errors.As(err, &lost) {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "lost connection to acme: %v\n", err)
+		// windows opened on the old connection are gone
+		unmount()
+		a.mux.Lock()
+		a.wins = make(map[int]WinInfo)
+		a.mux.Unlock()
+		if err := a.Reconnect.wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// lostError is returned by listen when acme could not be reached or
+// its log could no longer be read
+type lostError struct {
+	err error
+}
+
+func (e *lostError) Error() string {
+	return e.err.Error()
+}
+
+func (e *lostError) Unwrap() error {
+	return e.err
+}
+
+// listen runs a single session with acme, returning once the log can
+// no longer be read and every Buf of the session has stopped
+func (a *Acme) listen(ctx context.Context) error {
+	c, err := dial()
+	if err != nil {
+		return &lostError{err}
+	}
+	defer c.Close()
+	l, err := openLog(c)
 	if err != nil {
+		return &lostError{err}
+	}
+
+	// stop every Buf and wait for them to close their windows
+	// before returning
+	var running sync.WaitGroup
+	defer running.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// interrupt the pending log read
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	for _, hook := range a.ConnectHooks {
+		if err := hook(); err != nil {
+			return err
+		}
+	}
+	// the log is opened first so that windows created while
+	// attaching are not missed
+	if err := a.attach(ctx, &running); err != nil {
 		return err
 	}
 	for {
 		event, err := l.Read()
 		if err != nil {
-			return err
+			return &lostError{err}
 		}
+		a.logEvent(event)
 
-		// skip directory windows
-		if strings.HasSuffix(event.Name, "/") {
-			continue
+		switch event.Op {
+		case New, Zerox:
+			// create listener on new window events
+			running.Add(1)
+			go func(id int) {
+				defer running.Done()
+				a.startBuf(ctx, id)
+			}(event.ID)
+		case Del:
+			if b := a.bufs.Buf(event.ID); b != nil {
+				a.closeBuf(b)
+			}
 		}
+	}
+}
 
-		// create listener on new window events
-		if event.Op == "new" {
-			go a.startBuf(event.ID)
-		}
+// logEvent runs the hooks for the log operation in the order they
+// were registered
+func (a *Acme) logEvent(event LogEvent) {
+	for _, hook := range a.LogHooks[event.Op] {
+		hook(event)
+	}
+}
+
+// attach starts a Buf for every window that was opened before
+// the Acme began listening
+func (a *Acme) attach(ctx context.Context, running *sync.WaitGroup) error {
+	ws, err := index()
+	if err != nil {
+		return err
+	}
+	for _, info := range ws {
+		running.Add(1)
+		go func(info WinInfo) {
+			defer running.Done()
+			a.runBuf(ctx, info)
+		}(info)
 	}
+	return nil
 }
 
-func (a *Acme) startBuf(id int) {
+func (a *Acme) startBuf(ctx context.Context, id int) {
 	err := a.mapWindows()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%+v", err)
+		a.report(&HookError{ID: id, Hook: "start", Err: err})
+		return
+	}
+	a.mux.Lock()
+	info, ok := a.wins[id]
+	a.mux.Unlock()
+	if !ok {
+		// deleted before it could be started
 		return
 	}
-	if a.isDisabled(id) {
+	a.runBuf(ctx, info)
+}
+
+// runBuf runs a Buf on the window if it is one of Kinds
+func (a *Acme) runBuf(ctx context.Context, info WinInfo) {
+	if !a.attaches(Classify(info)) {
 		return
 	}
 
-	f := &Buf{
-		id:         id,
-		file:       a.wins[id],
-		EventHooks: a.EventHooks,
-		WinHooks:   a.WinHooks,
-		KeyHooks:   a.KeyHooks,
+	id, file := info.ID, info.Name
+	f := a.newBuf(id, file)
+	if !a.bufs.Add(f) {
+		// already attached
+		return
 	}
-	a.bufs[id] = f
+	defer a.closeBuf(f)
 
-	err = f.Start()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%+v", err)
+	err := f.StartContext(ctx)
+	if err != nil && err != ctx.Err() {
+		a.report(&HookError{ID: id, File: file, Hook: "start", Err: err})
+		return
+	}
+}
+
+// report passes the error to the Errors handler
+func (a *Acme) report(err *HookError) {
+	if a.Errors == nil {
+		StderrErrors(err)
 		return
 	}
+	a.Errors(err)
 }
 
-var disabledNames = []string{"/-", "Del", "xplor", "+Errors"}
+// RunWindow runs the hooks on the window until it is deleted or ctx is
+// done, without listening to the acme log for other windows
+func (a *Acme) RunWindow(ctx context.Context, w Window) error {
+	b := a.newBuf(w.ID(), w.File())
+	b.Open = func(int, string) (Window, error) {
+		return w, nil
+	}
+	if !a.bufs.Add(b) {
+		return fmt.Errorf("window %d is already running", w.ID())
+	}
+	defer a.closeBuf(b)
+	return b.StartContext(ctx)
+}
+
+// newBuf constructs a Buf that runs the hooks of the Acme
+func (a *Acme) newBuf(id int, file string) *Buf {
+	return &Buf{
+		id:          id,
+		file:        file,
+		Open:        a.Open,
+		Shadow:      a.Shadow,
+		Pool:        a.Pool,
+		HookTimeout: a.HookTimeout,
+		Errors:      a.Errors,
+		EventHooks:  a.EventHooks,
+		WinHooks:    a.WinHooks,
+		KeyHooks:    a.KeyHooks,
+		Router:      a.Router,
+		Trace:       a.Trace,
+	}
+}
+
+// closeBuf removes the Buf from the registry and runs the close
+// hooks. It is called both when the Buf stops and when acme logs
+// the window's deletion, but the hooks only run once.
+func (a *Acme) closeBuf(b *Buf) {
+	if !a.bufs.Remove(b) {
+		return
+	}
+	for _, hook := range a.CloseHooks {
+		hook(b)
+	}
+}
 
-func (a *Acme) isDisabled(id int) bool {
-	filename := a.wins[id]
-	for _, name := range disabledNames {
-		if strings.Contains(filename, name) {
+// attaches reports whether a Buf is started for windows of the kind
+func (a *Acme) attaches(kind WinKind) bool {
+	for _, k := range a.Kinds {
+		if k == kind {
 			return true
 		}
 	}
@@ -94,20 +302,27 @@ func (a *Acme) isDisabled(id int) bool {
 }
 
 func (a *Acme) mapWindows() error {
-	ws, err := acme.Windows()
+	ws, err := index()
 	if err != nil {
 		return err
 	}
 	a.mux.Lock()
 	defer a.mux.Unlock()
-	a.wins = make(map[int]string)
-	for _, w := range ws {
-		a.wins[w.ID] = w.Name
-	}
+	a.wins = ws
 	return nil
 }
 
 // Buf returns the running Buf by its ID
 func (a *Acme) Buf(id int) *Buf {
-	return a.bufs[id]
+	return a.bufs.Buf(id)
+}
+
+// Lookup returns the running Bufs for the file
+func (a *Acme) Lookup(file string) []*Buf {
+	return a.bufs.Lookup(file)
+}
+
+// Bufs returns all running Bufs
+func (a *Acme) Bufs() []*Buf {
+	return a.bufs.Bufs()
 }
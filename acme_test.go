@@ -0,0 +1,530 @@
+package nyne
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dnjp/nyne/acmetest"
+)
+
+var fsrv *acmetest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	fsrv, err = acmetest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	fsrv.Close()
+	os.Exit(code)
+}
+
+// waitFor polls cond until it returns true or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListenStartsBuf(t *testing.T) {
+	defer fsrv.Reset()
+	a := NewAcme()
+	opened := make(chan string, 1)
+	a.WinHooks[New] = []WinHandler{
+		func(w Window) {
+			opened <- w.File()
+		},
+	}
+	go a.Listen()
+	if err := fsrv.WaitLog(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw := fsrv.NewWindow("/tmp/listen.txt", "")
+	select {
+	case file := <-opened:
+		if file != "/tmp/listen.txt" {
+			t.Fatalf("unexpected file %s", file)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("New hook was not run")
+	}
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if b := a.Buf(fw.ID()); b == nil || b.File() != "/tmp/listen.txt" {
+		t.Fatalf("expected a running Buf for window %d", fw.ID())
+	}
+}
+
+func TestListenAttaches(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/existing.txt", "")
+	dir := fsrv.NewWindow("/tmp/", "")
+	errs := fsrv.NewWindow("/tmp/+Errors", "")
+
+	a := NewAcme()
+	opened := make(chan string, 2)
+	a.WinHooks[New] = []WinHandler{
+		func(w Window) {
+			opened <- w.File()
+		},
+	}
+	go a.Listen()
+	select {
+	case file := <-opened:
+		if file != "/tmp/existing.txt" {
+			t.Fatalf("unexpected file %s", file)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("New hook was not run for the existing window")
+	}
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if a.Buf(fw.ID()) == nil {
+		t.Fatalf("expected a running Buf for window %d", fw.ID())
+	}
+	if a.Buf(dir.ID()) != nil {
+		t.Fatal("directory windows should not be attached")
+	}
+	if a.Buf(errs.ID()) != nil {
+		t.Fatal("+Errors windows should not be attached")
+	}
+}
+
+func TestListenContext(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/cancel.txt", "")
+
+	a := NewAcme()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.ListenContext(ctx) }()
+	if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not stop")
+	}
+	waitFor(t, "event file to close", func() bool {
+		return !fw.EventOpen()
+	})
+}
+
+func TestReconnect(t *testing.T) {
+	defer fsrv.Reset()
+	a := NewAcme()
+	a.Reconnect = &Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond}
+	opened := make(chan string, 2)
+	a.WinHooks[New] = []WinHandler{
+		func(w Window) {
+			opened <- w.File()
+		},
+	}
+	var connects int32
+	a.ConnectHooks = []func() error{
+		func() error {
+			atomic.AddInt32(&connects, 1)
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.ListenContext(ctx)
+
+	for i, file := range []string{"/tmp/before.txt", "/tmp/after.txt"} {
+		fw := fsrv.NewWindow(file, "")
+		select {
+		case f := <-opened:
+			if f != file {
+				t.Fatalf("unexpected file %s", f)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("New hook was not run for %s", file)
+		}
+		if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if b := a.Buf(fw.ID()); b == nil || b.File() != file {
+			t.Fatalf("expected a running Buf for %s", file)
+		}
+		if n := atomic.LoadInt32(&connects); n != int32(i+1) {
+			t.Fatalf("expected %d connections, got %d", i+1, n)
+		}
+		if file == "/tmp/before.txt" {
+			if err := fsrv.Restart(50 * time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestLogHooks(t *testing.T) {
+	defer fsrv.Reset()
+	a := NewAcme()
+	logged := make(chan LogEvent, 4)
+	for _, op := range []Text{Focus, Del} {
+		a.LogHooks[op] = []LogHandler{
+			func(e LogEvent) {
+				logged <- e
+			},
+		}
+	}
+	go a.Listen()
+	if err := fsrv.WaitLog(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw := fsrv.NewWindow("/tmp/log.txt", "")
+	fsrv.Focus(fw.ID())
+	fsrv.Delete(fw.ID())
+	for _, op := range []Text{Focus, Del} {
+		select {
+		case e := <-logged:
+			if e.Op != op || e.ID != fw.ID() || e.Name != "/tmp/log.txt" {
+				t.Fatalf("expected %s event, got %+v", op, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s hook was not run", op)
+		}
+	}
+}
+
+func TestCloseHooks(t *testing.T) {
+	defer fsrv.Reset()
+	a := NewAcme()
+	closed := make(chan *Buf, 1)
+	a.CloseHooks = []BufHandler{
+		func(b *Buf) {
+			closed <- b
+		},
+	}
+	go a.Listen()
+	if err := fsrv.WaitLog(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw := fsrv.NewWindow("/tmp/close.txt", "")
+	if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if bufs := a.Lookup("/tmp/close.txt"); len(bufs) != 1 || bufs[0].id != fw.ID() {
+		t.Fatalf("expected to find the Buf by file name, got %v", bufs)
+	}
+	fsrv.Delete(fw.ID())
+	select {
+	case b := <-closed:
+		if b.id != fw.ID() {
+			t.Fatalf("unexpected Buf %d closed", b.id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close hook was not run")
+	}
+	if a.Buf(fw.ID()) != nil || len(a.Bufs()) != 0 {
+		t.Fatal("expected the Buf to be removed")
+	}
+}
+
+func TestBufKeyHook(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/key.txt", "")
+
+	b := NewBuf(fw.ID(), "/tmp/key.txt")
+	seen := make(chan Event, 1)
+	b.KeyHooks['x'] = func(e Event) (Event, bool) {
+		seen <- e
+		return e, true
+	}
+	go b.Start()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Type("x")
+	select {
+	case e := <-seen:
+		if e.Origin != Keyboard || e.Action != BodyInsert || e.Text != "x" {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("key hook was not run")
+	}
+}
+
+func TestBufDel(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/del.txt", "")
+
+	b := NewBuf(fw.ID(), "/tmp/del.txt")
+	done := make(chan error, 1)
+	go func() { done <- b.Start() }()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Exec("Del")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Buf did not stop after Del")
+	}
+	if !fw.Deleted() {
+		t.Fatal("Del was not passed back to acme")
+	}
+}
+
+// eventWindow is a Window that receives its events from a channel
+// and records the events written back to it
+type eventWindow struct {
+	Window
+	events  chan Event
+	written chan Event
+}
+
+func (w *eventWindow) EventChan(stop <-chan struct{}) (<-chan Event, <-chan error) {
+	return w.events, make(chan error)
+}
+
+func (w *eventWindow) WriteEvent(e Event) error {
+	w.written <- e
+	return nil
+}
+
+func (w *eventWindow) Close() {}
+
+func TestBufWindow(t *testing.T) {
+	w := &eventWindow{
+		events:  make(chan Event),
+		written: make(chan Event, 2),
+	}
+	b := NewBuf(1, "/tmp/window.txt")
+	b.Open = func(id int, file string) (Window, error) {
+		return w, nil
+	}
+	b.KeyHooks['x'] = func(e Event) (Event, bool) {
+		e.Text = "y"
+		return e, true
+	}
+	done := make(chan error, 1)
+	go func() { done <- b.Start() }()
+
+	w.events <- Event{Origin: Keyboard, Action: BodyInsert, Text: "x"}
+	if e := <-w.written; e.Text != "y" {
+		t.Fatalf("expected key hook to rewrite event, got %+v", e)
+	}
+	w.events <- Event{Origin: DelOrigin, Action: DelAction}
+	<-w.written
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Buf did not stop after Del")
+	}
+	if b.Win() != w {
+		t.Fatal("Buf did not use the opened Window")
+	}
+}
+
+func TestBufStartContext(t *testing.T) {
+	w := &eventWindow{
+		events:  make(chan Event),
+		written: make(chan Event, 1),
+	}
+	b := NewBuf(1, "/tmp/window.txt")
+	b.Open = func(id int, file string) (Window, error) {
+		return w, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.StartContext(ctx) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Buf did not stop")
+	}
+}
+
+func TestBufShadow(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/shadow.txt", "héllo\n")
+
+	b := NewBuf(fw.ID(), "/tmp/shadow.txt")
+	b.Shadow = true
+	bodies := make(chan string, 1)
+	b.EventHooks["Check"] = []Handler{
+		func(e Event) (Event, bool) {
+			body, err := b.Body()
+			if err != nil {
+				t.Error(err)
+			}
+			bodies <- string(body)
+			return e, true
+		},
+	}
+	go b.Start()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// edits from another program
+	w, err := OpenWin(fw.ID(), "/tmp/shadow.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	check := func(what string) {
+		t.Helper()
+		fw.Exec("Check")
+		select {
+		case body := <-bodies:
+			if body != fw.Body() {
+				t.Fatalf("%s: expected shadow %q, got %q", what, fw.Body(), body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("hook was not run")
+		}
+	}
+
+	fw.SetDot(5, 5)
+	fw.Type("ö wörld")
+	fw.Backspace(2)
+	check("typing")
+
+	if err := w.SetAddr("#1,#3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetData([]byte("E")); err != nil {
+		t.Fatal(err)
+	}
+	check("data")
+
+	// acme leaves the text out of long inserts
+	if err := w.AppendBody([]byte(strings.Repeat("ü", 300))); err != nil {
+		t.Fatal(err)
+	}
+	check("long insert")
+}
+
+// bodyWindow is a Window that counts how often its body is read
+type bodyWindow struct {
+	Window
+	reads int32
+}
+
+func (w *bodyWindow) Body() ([]byte, error) {
+	atomic.AddInt32(&w.reads, 1)
+	return w.Window.Body()
+}
+
+func TestBufShadowPut(t *testing.T) {
+	defer fsrv.Reset()
+	dir, err := ioutil.TempDir("", "nyne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "put.txt")
+	fw := fsrv.NewWindow(name, "one\ntwo\n")
+
+	var w *bodyWindow
+	b := NewBuf(fw.ID(), name)
+	b.Shadow = true
+	b.Open = func(id int, file string) (Window, error) {
+		win, err := OpenWindow(id, file)
+		w = &bodyWindow{Window: win}
+		return w, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.StartContext(ctx)
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fw.SetDot(4, 4)
+	fw.Type("x")
+	shown := fw.Shown()
+	fw.Exec("Put")
+	waitFor(t, "the address to be restored", func() bool {
+		return fw.Shown() > shown
+	})
+	if q0, _ := fw.Dot(); q0 != 4 {
+		t.Fatalf("expected dot at 4, got %d", q0)
+	}
+	// the body is only read to start the shadow
+	if n := atomic.LoadInt32(&w.reads); n != 1 {
+		t.Fatalf("expected the body to be read once, got %d", n)
+	}
+}
+
+func TestConnectHookError(t *testing.T) {
+	defer fsrv.Reset()
+	a := NewAcme()
+	a.Reconnect = &Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond}
+	hookErr := errors.New("no trace window")
+	a.ConnectHooks = []func() error{
+		func() error {
+			return hookErr
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- a.ListenContext(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != hookErr {
+			t.Fatalf("expected %v, got %v", hookErr, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the hook error to be returned instead of reconnecting")
+	}
+}
+
+func TestBackoffDelays(t *testing.T) {
+	testCases := []struct {
+		b        Backoff
+		min, max time.Duration
+	}{
+		{Backoff{}, DefaultBackoff.Min, DefaultBackoff.Max},
+		{Backoff{Min: time.Second}, time.Second, DefaultBackoff.Max},
+		{Backoff{Min: time.Minute}, time.Minute, time.Minute},
+		{Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond}, time.Millisecond, 10 * time.Millisecond},
+	}
+	for _, tc := range testCases {
+		min, max := tc.b.delays()
+		if min != tc.min || max != tc.max {
+			t.Fatalf("%+v: expected %v,%v, got %v,%v", tc.b, tc.min, tc.max, min, max)
+		}
+	}
+}
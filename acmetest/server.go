@@ -0,0 +1,735 @@
+// Package acmetest provides an in-memory stand-in for acme that serves
+// acme's 9P file system on a unix socket. Code that talks to acme
+// over 9P, such as 9fans.net/go/acme or nyne's Win and Buf, can be run
+// against it from go test without a display.
+//
+// The 9fans client mounts acme once per process, so a test binary
+// should start a single Server in TestMain and call Reset between
+// tests.
+package acmetest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"9fans.net/go/plan9"
+)
+
+// file kinds served for each window
+const (
+	qroot = iota
+	qlog
+	qindex
+	qnew
+	qnewctl
+	qwin
+	qaddr
+	qbody
+	qctl
+	qdata
+	qxdata
+	qevent
+	qtag
+	qerrors
+)
+
+var winfiles = map[string]int{
+	"addr":   qaddr,
+	"body":   qbody,
+	"ctl":    qctl,
+	"data":   qdata,
+	"xdata":  qxdata,
+	"event":  qevent,
+	"tag":    qtag,
+	"errors": qerrors,
+}
+
+var (
+	errNotFound = errors.New("file does not exist")
+	errPerm     = errors.New("permission denied")
+	errBadFid   = errors.New("unknown fid")
+)
+
+// Server is an in-memory acme file server
+type Server struct {
+	dir    string
+	tmp    bool
+	ln     net.Listener
+	mu     sync.Mutex
+	wins   map[int]*Window
+	nextid int
+	logs   map[*queue]bool
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// NewServer starts a Server listening on the acme service socket in
+// the namespace directory dir
+func NewServer(dir string) (*Server, error) {
+	ln, err := net.Listen("unix", filepath.Join(dir, "acme"))
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		dir:   dir,
+		ln:    ln,
+		wins:  make(map[int]*Window),
+		logs:  make(map[*queue]bool),
+		conns: make(map[net.Conn]bool),
+	}
+	go s.serve(ln)
+	return s, nil
+}
+
+// Start creates a temporary namespace, starts a Server in it and
+// points $NAMESPACE at it so that acme clients in this process connect
+// to the Server
+func Start() (*Server, error) {
+	dir, err := ioutil.TempDir("", "acmetest")
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewServer(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s.tmp = true
+	if err := os.Setenv("NAMESPACE", dir); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Namespace returns the namespace directory the Server listens in
+func (s *Server) Namespace() string {
+	return s.dir
+}
+
+// Close stops the Server and disconnects all clients
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	for q := range s.logs {
+		q.close()
+	}
+	ln := s.ln
+	s.mu.Unlock()
+	err := ln.Close()
+	if s.tmp {
+		os.RemoveAll(s.dir)
+	}
+	return err
+}
+
+// Reset deletes every window without logging the deletions and ends
+// all reads of the log file, so that listeners started by a test stop
+// before the next one begins
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+}
+
+// Restart simulates acme exiting and being started again. Every
+// client is disconnected and every window is discarded, and nothing
+// listens on the socket for down. Window IDs start from 1 again.
+func (s *Server) Restart(down time.Duration) error {
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.reset()
+	s.nextid = 0
+	ln := s.ln
+	s.mu.Unlock()
+	if err := ln.Close(); err != nil {
+		return err
+	}
+
+	time.Sleep(down)
+	ln, err := net.Listen("unix", filepath.Join(s.dir, "acme"))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	go s.serve(ln)
+	return nil
+}
+
+func (s *Server) reset() {
+	for id, w := range s.wins {
+		w.deleted = true
+		if w.events != nil {
+			w.events.close()
+			w.events = nil
+		}
+		delete(s.wins, id)
+	}
+	for q := range s.logs {
+		q.close()
+		delete(s.logs, q)
+	}
+}
+
+// NewWindow creates a window named name holding body, as if the file
+// had been opened in acme, and logs it as new
+func (s *Server) NewWindow(name, body string) *Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.newWindow(name, body)
+	s.log(w.id, "new", w.name)
+	return w
+}
+
+// Window returns the window with the given ID or nil
+func (s *Server) Window(id int) *Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wins[id]
+}
+
+// Lookup returns the first window with the given name or nil
+func (s *Server) Lookup(name string) *Window {
+	for _, w := range s.Windows() {
+		if w.Name() == name {
+			return w
+		}
+	}
+	return nil
+}
+
+// Windows returns all windows ordered by ID
+func (s *Server) Windows() []*Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+// WaitLog blocks until a client opens the log file or the timeout
+// expires
+func (s *Server) WaitLog(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mu.Lock()
+		n := len(s.logs)
+		s.mu.Unlock()
+		if n > 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("log file was not opened")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Focus logs that the window with the given ID received focus
+func (s *Server) Focus(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.wins[id]; ok {
+		s.log(id, "focus", w.name)
+	}
+}
+
+// Delete deletes the window with the given ID as if Delete had been
+// executed in it
+func (s *Server) Delete(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.wins[id]; ok {
+		s.delete(w)
+	}
+}
+
+func (s *Server) sorted() []*Window {
+	ws := make([]*Window, 0, len(s.wins))
+	for _, w := range s.wins {
+		ws = append(ws, w)
+	}
+	sort.Slice(ws, func(i, j int) bool { return ws[i].id < ws[j].id })
+	return ws
+}
+
+func (s *Server) newWindow(name, body string) *Window {
+	s.nextid++
+	w := newWindow(s, s.nextid, name, body)
+	s.wins[w.id] = w
+	return w
+}
+
+func (s *Server) delete(w *Window) {
+	if w.deleted {
+		return
+	}
+	w.deleted = true
+	delete(s.wins, w.id)
+	if w.events != nil {
+		w.events.close()
+		w.events = nil
+	}
+	s.log(w.id, "del", w.name)
+}
+
+// log writes an entry to every open log file
+func (s *Server) log(id int, op, name string) {
+	msg := []byte(fmt.Sprintf("%d %s %s\n", id, op, name))
+	for q := range s.logs {
+		q.write(msg)
+	}
+}
+
+// errorf appends a message to the +Errors window for the directory of
+// name, creating it if needed
+func (s *Server) errorf(name, format string, args ...interface{}) {
+	dir, _ := path.Split(name)
+	if dir == "/" || dir == "." {
+		dir = ""
+	}
+	ename := dir + "+Errors"
+	var ew *Window
+	for _, w := range s.wins {
+		if w.name == ename {
+			ew = w
+			break
+		}
+	}
+	if ew == nil {
+		ew = s.newWindow(ename, "")
+		s.log(ew.id, "new", ew.name)
+	}
+	msg := fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	ew.appendBody([]byte(msg))
+	ew.dirty = false
+}
+
+func (s *Server) serve(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		go newConn(s, c).serve()
+	}
+}
+
+// fid is a client's handle on a file
+type fid struct {
+	kind int
+	id   int
+	open bool
+	q    *queue
+}
+
+// conn serves 9P requests from a single client
+type conn struct {
+	srv   *Server
+	rwc   net.Conn
+	wmu   sync.Mutex
+	mu    sync.Mutex
+	fids  map[uint32]*fid
+	msize uint32
+}
+
+func newConn(s *Server, rwc net.Conn) *conn {
+	return &conn{
+		srv:   s,
+		rwc:   rwc,
+		fids:  make(map[uint32]*fid),
+		msize: 8192 + plan9.IOHDRSZ,
+	}
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.srv.mu.Lock()
+		delete(c.srv.conns, c.rwc)
+		c.mu.Lock()
+		for num, f := range c.fids {
+			c.clunk(f)
+			delete(c.fids, num)
+		}
+		c.mu.Unlock()
+		c.srv.mu.Unlock()
+		c.rwc.Close()
+	}()
+	for {
+		tx, err := plan9.ReadFcall(c.rwc)
+		if err != nil {
+			return
+		}
+		// reads of the event and log files block, so every request
+		// other than the version negotiation is served concurrently
+		if tx.Type == plan9.Tversion {
+			c.handle(tx)
+			continue
+		}
+		go c.handle(tx)
+	}
+}
+
+func (c *conn) handle(tx *plan9.Fcall) {
+	rx, err := c.rpc(tx)
+	if err != nil {
+		rx = &plan9.Fcall{Type: plan9.Rerror, Ename: err.Error()}
+	}
+	rx.Tag = tx.Tag
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	plan9.WriteFcall(c.rwc, rx)
+}
+
+func (c *conn) rpc(tx *plan9.Fcall) (*plan9.Fcall, error) {
+	switch tx.Type {
+	case plan9.Tversion:
+		if tx.Msize < c.msize {
+			c.msize = tx.Msize
+		}
+		return &plan9.Fcall{Type: plan9.Rversion, Msize: c.msize, Version: plan9.VERSION9P}, nil
+	case plan9.Tauth:
+		return nil, errors.New("acme: authentication not required")
+	case plan9.Tattach:
+		c.mu.Lock()
+		c.fids[tx.Fid] = &fid{kind: qroot}
+		c.mu.Unlock()
+		return &plan9.Fcall{Type: plan9.Rattach, Qid: qid(qroot, 0)}, nil
+	case plan9.Tflush:
+		return &plan9.Fcall{Type: plan9.Rflush}, nil
+	case plan9.Twalk:
+		return c.walk(tx)
+	case plan9.Topen:
+		return c.open(tx)
+	case plan9.Tread:
+		return c.read(tx)
+	case plan9.Twrite:
+		return c.write(tx)
+	case plan9.Tclunk:
+		c.srv.mu.Lock()
+		c.mu.Lock()
+		if f, ok := c.fids[tx.Fid]; ok {
+			c.clunk(f)
+			delete(c.fids, tx.Fid)
+		}
+		c.mu.Unlock()
+		c.srv.mu.Unlock()
+		return &plan9.Fcall{Type: plan9.Rclunk}, nil
+	case plan9.Tstat:
+		return c.stat(tx)
+	}
+	return nil, errPerm
+}
+
+func (c *conn) fid(num uint32) (*fid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.fids[num]
+	if !ok {
+		return nil, errBadFid
+	}
+	return f, nil
+}
+
+func (c *conn) walk(tx *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(tx.Fid)
+	if err != nil {
+		return nil, err
+	}
+	if f.open {
+		return nil, errors.New("walk of open fid")
+	}
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	nf := &fid{kind: f.kind, id: f.id}
+	var qids []plan9.Qid
+	for _, name := range tx.Wname {
+		switch {
+		case name == "..":
+			nf.kind, nf.id = qroot, 0
+		case nf.kind == qroot && name == "log":
+			nf.kind = qlog
+		case nf.kind == qroot && name == "index":
+			nf.kind = qindex
+		case nf.kind == qroot && name == "new":
+			nf.kind = qnew
+		case nf.kind == qnew && name == "ctl":
+			nf.kind = qnewctl
+		case nf.kind == qroot:
+			id, err := strconv.Atoi(name)
+			if _, ok := c.srv.wins[id]; err != nil || !ok {
+				goto Done
+			}
+			nf.kind, nf.id = qwin, id
+		case nf.kind == qwin:
+			k, ok := winfiles[name]
+			if !ok {
+				goto Done
+			}
+			nf.kind = k
+		default:
+			goto Done
+		}
+		qids = append(qids, qid(nf.kind, nf.id))
+	}
+Done:
+	if len(qids) == 0 && len(tx.Wname) > 0 {
+		return nil, errNotFound
+	}
+	if len(qids) == len(tx.Wname) {
+		c.mu.Lock()
+		c.fids[tx.Newfid] = nf
+		c.mu.Unlock()
+	}
+	return &plan9.Fcall{Type: plan9.Rwalk, Wqid: qids}, nil
+}
+
+func (c *conn) open(tx *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(tx.Fid)
+	if err != nil {
+		return nil, err
+	}
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	switch f.kind {
+	case qnewctl:
+		w := c.srv.newWindow("", "")
+		c.srv.log(w.id, "new", w.name)
+		f.kind, f.id = qctl, w.id
+	case qlog:
+		f.q = newQueue()
+		c.srv.logs[f.q] = true
+	case qroot, qwin, qnew, qindex:
+	default:
+		w, ok := c.srv.wins[f.id]
+		if !ok {
+			return nil, errDeleted
+		}
+		switch f.kind {
+		case qevent:
+			if w.events != nil {
+				return nil, errInUse
+			}
+			w.events = newQueue()
+			f.q = w.events
+		case qaddr, qdata, qxdata:
+			if w.nopen[file(qaddr)]+w.nopen[file(qdata)]+w.nopen[file(qxdata)] == 0 {
+				w.addr = span{}
+				w.limit = nil
+			}
+		}
+		w.nopen[file(f.kind)]++
+	}
+	f.open = true
+	return &plan9.Fcall{Type: plan9.Ropen, Qid: qid(f.kind, f.id)}, nil
+}
+
+// clunk releases the resources held by f. The caller holds both the
+// server and connection locks.
+func (c *conn) clunk(f *fid) {
+	if !f.open {
+		return
+	}
+	switch f.kind {
+	case qlog:
+		f.q.close()
+		delete(c.srv.logs, f.q)
+	case qevent:
+		f.q.close()
+		if w, ok := c.srv.wins[f.id]; ok && w.events == f.q {
+			w.events = nil
+		}
+	}
+	if w, ok := c.srv.wins[f.id]; ok && f.kind > qwin {
+		w.nopen[file(f.kind)]--
+		if f.kind == qaddr || f.kind == qdata || f.kind == qxdata {
+			w.nomark = false
+		}
+	}
+	f.open = false
+}
+
+func (c *conn) read(tx *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(tx.Fid)
+	if err != nil {
+		return nil, err
+	}
+	n := int(tx.Count)
+	if max := int(c.msize) - plan9.IOHDRSZ; n > max {
+		n = max
+	}
+	// the event and log files block until there is something to read
+	if f.kind == qevent || f.kind == qlog {
+		if !f.open {
+			return nil, errPerm
+		}
+		b, err := f.q.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return &plan9.Fcall{Type: plan9.Rread, Data: b}, nil
+	}
+
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	var text string
+	switch f.kind {
+	case qroot, qwin, qnew:
+		return &plan9.Fcall{Type: plan9.Rread}, nil
+	case qindex:
+		var b strings.Builder
+		for _, w := range c.srv.sorted() {
+			b.WriteString(w.indexText())
+		}
+		text = b.String()
+	default:
+		w, ok := c.srv.wins[f.id]
+		if !ok {
+			return nil, errDeleted
+		}
+		switch f.kind {
+		case qdata, qxdata:
+			return &plan9.Fcall{Type: plan9.Rread, Data: w.readData(n, f.kind == qxdata)}, nil
+		case qaddr:
+			text = fmt.Sprintf("%11d %11d ", w.addr.q0, w.addr.q1)
+		case qbody:
+			text = string(w.body)
+		case qctl:
+			text = w.ctlText()
+		case qtag:
+			text = string(w.tagText())
+		default:
+			return nil, errPerm
+		}
+	}
+	off := int(tx.Offset)
+	if off > len(text) {
+		off = len(text)
+	}
+	end := off + n
+	if end > len(text) {
+		end = len(text)
+	}
+	return &plan9.Fcall{Type: plan9.Rread, Data: []byte(text[off:end])}, nil
+}
+
+func (c *conn) write(tx *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(tx.Fid)
+	if err != nil {
+		return nil, err
+	}
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	w, ok := c.srv.wins[f.id]
+	if !ok || f.kind <= qwin {
+		if f.kind <= qwin {
+			return nil, errPerm
+		}
+		return nil, errDeleted
+	}
+	switch f.kind {
+	case qaddr:
+		err = w.setAddr(tx.Data)
+	case qbody:
+		w.appendBody(tx.Data)
+	case qctl:
+		err = w.ctl(tx.Data)
+	case qdata, qxdata:
+		err = w.writeData(tx.Data)
+	case qevent:
+		err = w.writeEvent(tx.Data)
+	case qtag:
+		w.tag = append(w.tag, []rune(string(tx.Data))...)
+	case qerrors:
+		c.srv.errorf(w.name, "%s", tx.Data)
+	default:
+		err = errPerm
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan9.Fcall{Type: plan9.Rwrite, Count: uint32(len(tx.Data))}, nil
+}
+
+func (c *conn) stat(tx *plan9.Fcall) (*plan9.Fcall, error) {
+	f, err := c.fid(tx.Fid)
+	if err != nil {
+		return nil, err
+	}
+	d := plan9.Dir{
+		Qid:  qid(f.kind, f.id),
+		Name: file(f.kind),
+		Uid:  os.Getenv("USER"),
+		Gid:  os.Getenv("USER"),
+		Mode: 0600,
+	}
+	if f.kind == qwin {
+		d.Name = strconv.Itoa(f.id)
+	}
+	if d.Qid.Type&plan9.QTDIR != 0 {
+		d.Mode = plan9.DMDIR | 0700
+	}
+	b, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &plan9.Fcall{Type: plan9.Rstat, Stat: b}, nil
+}
+
+func qid(kind, id int) plan9.Qid {
+	q := plan9.Qid{Path: uint64(id)<<8 | uint64(kind)}
+	switch kind {
+	case qroot, qnew, qwin:
+		q.Type = plan9.QTDIR
+	case qlog, qevent:
+		q.Type = plan9.QTAPPEND
+	}
+	return q
+}
+
+func file(kind int) string {
+	switch kind {
+	case qroot:
+		return "/"
+	case qlog:
+		return "log"
+	case qindex:
+		return "index"
+	case qnew:
+		return "new"
+	case qnewctl:
+		return "ctl"
+	}
+	for name, k := range winfiles {
+		if k == kind {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package acmetest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"9fans.net/go/acme"
+)
+
+var srv *Server
+
+func TestMain(m *testing.M) {
+	var err error
+	srv, err = Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
+func TestWindowFiles(t *testing.T) {
+	defer srv.Reset()
+	fw := srv.NewWindow("/tmp/test.txt", "hello world\n")
+
+	ws, err := acme.Windows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ws) != 1 || ws[0].ID != fw.ID() || ws[0].Name != "/tmp/test.txt" {
+		t.Fatalf("unexpected windows: %+v", ws)
+	}
+
+	w, err := acme.Open(fw.ID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.CloseFiles()
+
+	if err := w.Addr("#6;+#5"); err != nil {
+		t.Fatal(err)
+	}
+	q0, q1, err := w.ReadAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q0 != 6 || q1 != 11 {
+		t.Fatalf("expected addr 6,11, got %d,%d", q0, q1)
+	}
+	if _, err := w.Write("data", []byte("acme")); err != nil {
+		t.Fatal(err)
+	}
+	body, err := w.ReadAll("body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello acme\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if !fw.Dirty() {
+		t.Fatal("expected window to be dirty")
+	}
+
+	if err := w.Fprintf("tag", " |fmt"); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := w.ReadAll("tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(tag), "/tmp/test.txt Del Snarf") || !strings.HasSuffix(string(tag), " |fmt") {
+		t.Fatalf("unexpected tag %q", tag)
+	}
+	if err := w.Ctl("cleartag"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(fw.Tag(), "|fmt") {
+		t.Fatalf("tag was not cleared: %q", fw.Tag())
+	}
+}
+
+func TestEvents(t *testing.T) {
+	defer srv.Reset()
+	fw := srv.NewWindow("/tmp/events.txt", "")
+
+	w, err := acme.Open(fw.ID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.CloseFiles()
+	events := w.EventChan()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Type("a")
+	e := <-events
+	if e.C1 != 'K' || e.C2 != 'I' || e.Q0 != 0 || e.Q1 != 1 || string(e.Text) != "a" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	fw.Exec("Undo")
+	e = <-events
+	if e.C1 != 'M' || e.C2 != 'x' || string(e.Text) != "Undo" || e.Flag&1 == 0 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if fw.Body() != "a" {
+		t.Fatal("acme ran the command before the event was written back")
+	}
+	if err := w.WriteEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	e = <-events
+	if e.C2 != 'D' {
+		t.Fatalf("expected delete event, got %+v", e)
+	}
+	if fw.Body() != "" {
+		t.Fatalf("expected Undo to remove the text, got %q", fw.Body())
+	}
+}
+
+func TestLog(t *testing.T) {
+	defer srv.Reset()
+	l, err := acme.Log()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fw := srv.NewWindow("/tmp/log.txt", "")
+	srv.Focus(fw.ID())
+	fw.Exec("Del")
+	for _, op := range []string{"new", "focus", "del"} {
+		e, err := l.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != fw.ID() || e.Op != op || e.Name != "/tmp/log.txt" {
+			t.Fatalf("expected %s event, got %+v", op, e)
+		}
+	}
+	if !fw.Deleted() {
+		t.Fatal("expected window to be deleted")
+	}
+}
+
+func TestNewWindow(t *testing.T) {
+	defer srv.Reset()
+	w, err := acme.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.CloseFiles()
+	if err := w.Name("/tmp/+new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Fprintf("body", "output\n"); err != nil {
+		t.Fatal(err)
+	}
+	fw := srv.Lookup("/tmp/+new")
+	if fw == nil {
+		t.Fatal("window was not created")
+	}
+	if fw.Body() != "output\n" {
+		t.Fatalf("unexpected body %q", fw.Body())
+	}
+}
@@ -0,0 +1,814 @@
+package acmetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dnjp/nyne/addr"
+)
+
+// eventSize is the largest text acme includes in an event message
+const eventSize = 256
+
+// span is a range of runes in a window body
+type span struct {
+	q0, q1 int
+}
+
+// DefaultFont is the font reported by a window's ctl file
+const DefaultFont = "/lib/font/bit/lucsans/euro.8.font"
+
+var (
+	errBadCtl   = errors.New("ill-formed control message")
+	errBadEvent = errors.New("bad event syntax")
+	errDirty    = errors.New("file dirty")
+	errDeleted  = errors.New("window deleted")
+	errInUse    = errors.New("file already in use")
+)
+
+// builtins are the commands acme executes itself
+var builtins = map[string]bool{
+	"Cut": true, "Del": true, "Delcol": true, "Delete": true,
+	"Dump": true, "Edit": true, "Exit": true, "Font": true,
+	"Get": true, "ID": true, "Incl": true, "Indent": true,
+	"Kill": true, "Load": true, "Local": true, "Look": true,
+	"New": true, "Newcol": true, "Paste": true, "Put": true,
+	"Putall": true, "Redo": true, "Send": true, "Snarf": true,
+	"Sort": true, "Tab": true, "Undo": true, "Zerox": true,
+}
+
+// edit records a single change to the body for Undo and Redo
+type edit struct {
+	seq int
+	q0  int
+	del []rune
+	ins []rune
+}
+
+// Window is an acme window held in memory by a Server
+type Window struct {
+	srv      *Server
+	id       int
+	name     string
+	tag      []rune
+	body     []rune
+	addr     span
+	dot      span
+	limit    *span
+	dirty    bool
+	nomark   bool
+	seq      int
+	undo     []edit
+	redo     []edit
+	tab      int
+	font     string
+	dump     string
+	dumpdir  string
+	shown    int
+	delwarn  bool
+	deleted  bool
+	executed []string
+	looked   []string
+	events   *queue
+	nopen    map[string]int
+}
+
+func newWindow(srv *Server, id int, name string, body string) *Window {
+	return &Window{
+		srv:   srv,
+		id:    id,
+		name:  name,
+		tag:   []rune(" Look "),
+		body:  []rune(body),
+		tab:   4,
+		font:  DefaultFont,
+		nopen: make(map[string]int),
+	}
+}
+
+// ID returns the window ID
+func (w *Window) ID() int {
+	return w.id
+}
+
+// Name returns the window name
+func (w *Window) Name() string {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.name
+}
+
+// Body returns the contents of the window body
+func (w *Window) Body() string {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return string(w.body)
+}
+
+// SetBody replaces the body without generating events, as if the
+// file had just been loaded
+func (w *Window) SetBody(body string) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	w.body = []rune(body)
+	w.undo, w.redo = nil, nil
+	w.dot, w.addr = span{}, span{}
+	w.dirty = false
+}
+
+// Tag returns the full text of the window tag
+func (w *Window) Tag() string {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return string(w.tagText())
+}
+
+// Dot returns the current selection
+func (w *Window) Dot() (q0, q1 int) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.dot.q0, w.dot.q1
+}
+
+// SetDot sets the selection, as if the user had clicked in the body
+func (w *Window) SetDot(q0, q1 int) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	w.dot = w.clamp(span{q0, q1})
+}
+
+// Dirty reports whether the body has been modified since it was
+// last written
+func (w *Window) Dirty() bool {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.dirty
+}
+
+// Tabwidth returns the tab width set with the Tab command
+func (w *Window) Tabwidth() int {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.tab
+}
+
+// Font returns the font set through the ctl file
+func (w *Window) Font() string {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.font
+}
+
+// Dump returns the dump command and directory set through the ctl file
+func (w *Window) Dump() (cmd, dir string) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.dump, w.dumpdir
+}
+
+// Shown returns the number of times show was written to ctl
+func (w *Window) Shown() int {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.shown
+}
+
+// Executed returns every command acme executed in the window,
+// builtin or external, in order
+func (w *Window) Executed() []string {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return append([]string(nil), w.executed...)
+}
+
+// Looked returns every text acme looked up in the window
+func (w *Window) Looked() []string {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return append([]string(nil), w.looked...)
+}
+
+// Deleted reports whether the window has been deleted
+func (w *Window) Deleted() bool {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.deleted
+}
+
+// EventOpen reports whether a client has the event file open
+func (w *Window) EventOpen() bool {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	return w.events != nil
+}
+
+// WaitEventOpen blocks until a client opens the event file or the
+// timeout expires
+func (w *Window) WaitEventOpen(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !w.EventOpen() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("event file for window %d was not opened", w.id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+// Type inserts text at the selection as if it had been typed, and
+// reports the insertion to the event file
+func (w *Window) Type(text string) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	r := []rune(text)
+	q0, q1 := w.dot.q0, w.dot.q1
+	w.replace('K', q0, q1, r, true)
+	w.dot = span{q0 + len(r), q0 + len(r)}
+}
+
+// Backspace deletes the n runes before the selection as if they had
+// been erased with the keyboard
+func (w *Window) Backspace(n int) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	q1 := w.dot.q0
+	q0 := q1 - n
+	if q0 < 0 {
+		q0 = 0
+	}
+	w.replace('K', q0, q1, nil, true)
+	w.dot = span{q0, q0}
+}
+
+// Exec executes cmd as if it had been middle clicked in the tag. The
+// command is added to the tag if it is not already there. If a client
+// has the event file open, the click is sent to it and acme only runs
+// the command once the event is written back.
+func (w *Window) Exec(cmd string) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	if w.deleted {
+		return
+	}
+	tag := string(w.tagText())
+	i := strings.LastIndex(tag, cmd)
+	if i < 0 {
+		w.tag = append(w.tag, []rune(" "+cmd)...)
+		tag = string(w.tagText())
+		i = strings.LastIndex(tag, cmd)
+	}
+	q0 := utf8.RuneCountInString(tag[:i])
+	q1 := q0 + utf8.RuneCountInString(cmd)
+	if w.events == nil {
+		w.execute('M', cmd)
+		return
+	}
+	flag := 0
+	if isBuiltin(cmd) {
+		flag |= 1
+	}
+	w.event('M', 'x', q0, q1, flag, []rune(cmd))
+}
+
+// ExecArg executes cmd in the tag with the body text between q0 and q1
+// as its argument, as if cmd had been middle clicked while holding
+// button 1 over the argument. The argument is lost if the event is
+// written back, as it is in acme.
+func (w *Window) ExecArg(cmd string, q0, q1 int) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	if w.deleted {
+		return
+	}
+	r := w.clamp(span{q0, q1})
+	arg := w.body[r.q0:r.q1]
+	if w.events == nil {
+		w.execute('M', cmd+" "+string(arg))
+		return
+	}
+	tag := string(w.tagText())
+	i := strings.LastIndex(tag, cmd)
+	if i < 0 {
+		w.tag = append(w.tag, []rune(" "+cmd)...)
+		tag = string(w.tagText())
+		i = strings.LastIndex(tag, cmd)
+	}
+	cq0 := utf8.RuneCountInString(tag[:i])
+	cq1 := cq0 + utf8.RuneCountInString(cmd)
+	flag := 8
+	if isBuiltin(cmd) {
+		flag |= 1
+	}
+	loc := fmt.Sprintf("%s:#%d,#%d", w.name, r.q0, r.q1)
+	w.event('M', 'x', cq0, cq1, flag, []rune(cmd))
+	w.event('M', 'x', 0, 0, 0, arg)
+	w.event('M', 'x', 0, 0, 0, []rune(loc))
+}
+
+// ExecAt executes the word around q in the body as if it had been
+// middle clicked with a null selection, which acme reports as a null
+// event followed by its expansion
+func (w *Window) ExecAt(q int) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	if w.deleted {
+		return
+	}
+	r := w.clamp(span{q, q})
+	q0, q1 := r.q0, r.q1
+	for q0 > 0 && !unicode.IsSpace(w.body[q0-1]) {
+		q0--
+	}
+	for q1 < len(w.body) && !unicode.IsSpace(w.body[q1]) {
+		q1++
+	}
+	word := w.body[q0:q1]
+	if w.events == nil {
+		w.execute('M', string(word))
+		return
+	}
+	flag := 2
+	if isBuiltin(string(word)) {
+		flag |= 1
+	}
+	w.event('M', 'X', r.q0, r.q0, flag, nil)
+	w.event('M', 'X', q0, q1, 0, word)
+}
+
+// Look looks up text as if it had been right clicked in the body
+func (w *Window) Look(q0, q1 int) {
+	w.srv.mu.Lock()
+	defer w.srv.mu.Unlock()
+	r := w.clamp(span{q0, q1})
+	text := w.body[r.q0:r.q1]
+	if w.events == nil {
+		w.looked = append(w.looked, string(text))
+		return
+	}
+	w.event('M', 'L', r.q0, r.q1, 0, text)
+}
+
+func isBuiltin(cmd string) bool {
+	f := strings.Fields(cmd)
+	return len(f) > 0 && builtins[f[0]]
+}
+
+func (w *Window) isdir() bool {
+	return strings.HasSuffix(w.name, "/")
+}
+
+func (w *Window) tagText() []rune {
+	var b strings.Builder
+	b.WriteString(w.name)
+	b.WriteString(" Del Snarf")
+	if len(w.undo) > 0 {
+		b.WriteString(" Undo")
+	}
+	if len(w.redo) > 0 {
+		b.WriteString(" Redo")
+	}
+	if w.dirty && !w.isdir() {
+		b.WriteString(" Put")
+	}
+	b.WriteString(" |")
+	return append([]rune(b.String()), w.tag...)
+}
+
+func (w *Window) ctlText() string {
+	return fmt.Sprintf("%11d %11d %11d %11d %11d %11d %s %11d ",
+		w.id, len(w.tagText()), len(w.body), btoi(w.isdir()), btoi(w.dirty),
+		0, w.font, w.tab)
+}
+
+func (w *Window) indexText() string {
+	tag := string(w.tagText())
+	if i := strings.IndexByte(tag, '\n'); i >= 0 {
+		tag = tag[:i]
+	}
+	return fmt.Sprintf("%11d %11d %11d %11d %11d %s\n",
+		w.id, len(w.tagText()), len(w.body), btoi(w.isdir()), btoi(w.dirty), tag)
+}
+
+func (w *Window) clamp(s span) span {
+	if s.q0 < 0 {
+		s.q0 = 0
+	}
+	if s.q1 > len(w.body) {
+		s.q1 = len(w.body)
+	}
+	if s.q0 > s.q1 {
+		s.q0 = s.q1
+	}
+	return s
+}
+
+func (w *Window) lim() span {
+	if w.limit != nil {
+		return w.clamp(*w.limit)
+	}
+	return span{0, len(w.body)}
+}
+
+// event queues an event message for the client holding the event file
+func (w *Window) event(c1, c2 rune, q0, q1, flag int, text []rune) {
+	if w.events == nil {
+		return
+	}
+	nr := len(text)
+	if nr > eventSize {
+		nr = 0
+		text = nil
+	}
+	w.events.write([]byte(fmt.Sprintf("%c%c%d %d %d %d %s\n",
+		c1, c2, q0, q1, flag, nr, string(text))))
+}
+
+// replace replaces the runes between q0 and q1 with text, reporting
+// the change to the event file as coming from origin
+func (w *Window) replace(origin rune, q0, q1 int, text []rune, mark bool) {
+	if mark || w.seq == 0 {
+		w.seq++
+	}
+	del := append([]rune(nil), w.body[q0:q1]...)
+	ins := append([]rune(nil), text...)
+	w.undo = append(w.undo, edit{seq: w.seq, q0: q0, del: del, ins: ins})
+	w.redo = nil
+	w.apply(origin, q0, del, ins)
+}
+
+func (w *Window) apply(origin rune, q0 int, del, ins []rune) {
+	q1 := q0 + len(del)
+	if q1 > q0 {
+		w.body = append(w.body[:q0], w.body[q1:]...)
+		w.dot = shiftDelete(w.dot, q0, q1)
+		w.event(origin, 'D', q0, q1, 0, nil)
+	}
+	if len(ins) > 0 {
+		body := make([]rune, 0, len(w.body)+len(ins))
+		body = append(body, w.body[:q0]...)
+		body = append(body, ins...)
+		body = append(body, w.body[q0:]...)
+		w.body = body
+		w.dot = shiftInsert(w.dot, q0, len(ins))
+		w.event(origin, 'I', q0, q0+len(ins), 0, ins)
+	}
+	w.dirty = true
+}
+
+func shiftDelete(s span, q0, q1 int) span {
+	n := q1 - q0
+	if q0 < s.q1 {
+		s.q1 -= min(n, s.q1-q0)
+	}
+	if q0 < s.q0 {
+		s.q0 -= min(n, s.q0-q0)
+	}
+	return s
+}
+
+func shiftInsert(s span, q0, n int) span {
+	if q0 < s.q1 {
+		s.q1 += n
+	}
+	if q0 < s.q0 {
+		s.q0 += n
+	}
+	return s
+}
+
+func (w *Window) undoLast(origin rune) {
+	if len(w.undo) == 0 {
+		return
+	}
+	seq := w.undo[len(w.undo)-1].seq
+	for len(w.undo) > 0 && w.undo[len(w.undo)-1].seq == seq {
+		e := w.undo[len(w.undo)-1]
+		w.undo = w.undo[:len(w.undo)-1]
+		w.apply(origin, e.q0, e.ins, e.del)
+		w.redo = append(w.redo, e)
+	}
+}
+
+func (w *Window) redoLast(origin rune) {
+	if len(w.redo) == 0 {
+		return
+	}
+	seq := w.redo[len(w.redo)-1].seq
+	for len(w.redo) > 0 && w.redo[len(w.redo)-1].seq == seq {
+		e := w.redo[len(w.redo)-1]
+		w.redo = w.redo[:len(w.redo)-1]
+		w.apply(origin, e.q0, e.del, e.ins)
+		w.undo = append(w.undo, e)
+	}
+}
+
+// execute runs cmd as acme would after a middle click
+func (w *Window) execute(origin rune, cmd string) {
+	cmd = strings.TrimSpace(cmd)
+	if cmd == "" {
+		return
+	}
+	w.executed = append(w.executed, cmd)
+	f := strings.Fields(cmd)
+	switch f[0] {
+	case "Del":
+		if w.dirty && !w.delwarn {
+			w.delwarn = true
+			w.srv.errorf(w.name, "%s modified", w.name)
+			return
+		}
+		w.srv.delete(w)
+	case "Delete":
+		w.srv.delete(w)
+	case "Get":
+		if err := w.get(); err != nil {
+			w.srv.errorf(w.name, "%v", err)
+		}
+	case "Put":
+		if err := w.put(); err != nil {
+			w.srv.errorf(w.name, "%v", err)
+		}
+	case "Undo":
+		w.undoLast(origin)
+	case "Redo":
+		w.redoLast(origin)
+	case "Tab":
+		if len(f) > 1 {
+			if n, err := strconv.Atoi(f[1]); err == nil && n > 0 {
+				w.tab = n
+			}
+		}
+	case "Zerox":
+		z := w.srv.newWindow(w.name, string(w.body))
+		w.srv.log(z.id, "zerox", z.name)
+	}
+}
+
+func (w *Window) get() error {
+	if filepath.IsAbs(w.name) && !w.isdir() {
+		b, err := ioutil.ReadFile(w.name)
+		if err != nil {
+			return err
+		}
+		w.body = []rune(string(b))
+		w.undo, w.redo = nil, nil
+		w.dot = w.clamp(w.dot)
+	}
+	w.dirty = false
+	w.delwarn = false
+	w.srv.log(w.id, "get", w.name)
+	return nil
+}
+
+func (w *Window) put() error {
+	if filepath.IsAbs(w.name) && !w.isdir() {
+		err := ioutil.WriteFile(w.name, []byte(string(w.body)), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	w.dirty = false
+	w.delwarn = false
+	w.srv.log(w.id, "put", w.name)
+	return nil
+}
+
+// ctl executes the control messages in data
+func (w *Window) ctl(data []byte) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		verb, arg := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch verb {
+		case "name":
+			w.name = arg
+		case "dump":
+			w.dump = arg
+		case "dumpdir":
+			w.dumpdir = arg
+		case "font":
+			w.font = arg
+		case "get":
+			if err := w.get(); err != nil {
+				return err
+			}
+		case "put":
+			if err := w.put(); err != nil {
+				return err
+			}
+		case "del":
+			if w.dirty {
+				return errDirty
+			}
+			w.srv.delete(w)
+			return nil
+		case "delete":
+			w.srv.delete(w)
+			return nil
+		case "clean":
+			w.dirty = false
+		case "dirty":
+			w.dirty = true
+		case "show":
+			w.shown++
+		case "cleartag":
+			w.tag = nil
+		case "addr=dot":
+			w.addr = w.dot
+		case "dot=addr":
+			w.dot = w.addr
+		case "limit=addr":
+			l := w.addr
+			w.limit = &l
+		case "mark":
+			w.seq++
+			w.nomark = false
+		case "nomark":
+			w.nomark = true
+		case "menu", "nomenu":
+		default:
+			return errBadCtl
+		}
+	}
+	return nil
+}
+
+// writeEvent handles an event written back by the client
+func (w *Window) writeEvent(data []byte) error {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		r := []rune(string(line))
+		if len(r) < 2 {
+			return errBadEvent
+		}
+		origin, c := r[0], r[1]
+		var q0, q1 int
+		if _, err := fmt.Sscanf(string(r[2:]), "%d %d", &q0, &q1); err != nil {
+			return errBadEvent
+		}
+		var text []rune
+		switch c {
+		case 'x', 'l':
+			tag := w.tagText()
+			if q0 < 0 || q1 > len(tag) || q0 > q1 {
+				return errBadEvent
+			}
+			text = tag[q0:q1]
+		case 'X', 'L':
+			if q0 < 0 || q1 > len(w.body) || q0 > q1 {
+				return errBadEvent
+			}
+			text = w.body[q0:q1]
+		default:
+			return errBadEvent
+		}
+		switch c {
+		case 'x', 'X':
+			w.execute(origin, string(text))
+		case 'l', 'L':
+			w.looked = append(w.looked, string(text))
+		}
+		if w.deleted {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readData reads whole runes from the addr address that fit in n
+// bytes, stopping at the end of the address if bounded
+func (w *Window) readData(n int, bounded bool) []byte {
+	end := len(w.body)
+	if bounded {
+		end = w.addr.q1
+	}
+	var buf []byte
+	q := w.addr.q0
+	for q < end {
+		var rb [utf8.UTFMax]byte
+		m := utf8.EncodeRune(rb[:], w.body[q])
+		if len(buf)+m > n {
+			break
+		}
+		buf = append(buf, rb[:m]...)
+		q++
+	}
+	if bounded {
+		w.addr = span{q, end}
+	} else {
+		w.addr = span{q, q}
+	}
+	return buf
+}
+
+// writeData replaces the addr address with data
+func (w *Window) writeData(data []byte) error {
+	if !utf8.Valid(data) {
+		return errors.New("partial rune in data")
+	}
+	r := []rune(string(data))
+	a := w.clamp(w.addr)
+	w.replace('F', a.q0, a.q1, r, !w.nomark)
+	w.addr = span{a.q0 + len(r), a.q0 + len(r)}
+	return nil
+}
+
+// appendBody appends data to the body, as a write to the body file
+func (w *Window) appendBody(data []byte) {
+	r := []rune(string(data))
+	q := len(w.body)
+	w.replace('E', q, q, r, !w.nomark)
+}
+
+// setAddr evaluates the address in data relative to the addr address
+func (w *Window) setAddr(data []byte) error {
+	a := strings.TrimRight(string(data), "\n")
+	lim, dot := w.lim(), w.clamp(w.addr)
+	r, err := addr.EvalLimit(w.body,
+		addr.Range{Q0: lim.q0, Q1: lim.q1},
+		addr.Range{Q0: dot.q0, Q1: dot.q1}, a)
+	if err != nil {
+		return err
+	}
+	w.addr = span{r.Q0, r.Q1}
+	return nil
+}
+
+// queue is a blocking message stream used for the event and log
+// files. Like acme, a read never returns more than one message.
+type queue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	msgs   [][]byte
+	closed bool
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *queue) write(b []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.msgs = append(q.msgs, b)
+	q.cond.Broadcast()
+}
+
+func (q *queue) read(n int) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.msgs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.msgs) == 0 {
+		return nil, errDeleted
+	}
+	m := q.msgs[0]
+	if n >= len(m) {
+		q.msgs = q.msgs[1:]
+		return m, nil
+	}
+	q.msgs[0] = m[n:]
+	return m[:n], nil
+}
+
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,369 @@
+// Package addr evaluates acme addresses, such as -/^/;+2 or #10,$,
+// against a body held in memory. Tools can resolve addresses without
+// asking acme and test them without a running acme.
+package addr
+
+import (
+	"errors"
+	"regexp"
+)
+
+var (
+	// ErrSyntax is returned for malformed addresses
+	ErrSyntax = errors.New("bad address syntax")
+	// ErrRange is returned for addresses outside of the body
+	ErrRange = errors.New("address out of range")
+	// ErrNoMatch is returned when a regular expression does not match
+	ErrNoMatch = errors.New("no match for regexp")
+	// ErrOrder is returned when the second address of a range is
+	// before the first
+	ErrOrder = errors.New("addresses out of order")
+)
+
+// Range is a range of runes in a body
+type Range struct {
+	Q0, Q1 int
+}
+
+const (
+	dirNone = 0
+	dirFore = '+'
+	dirBack = '-'
+)
+
+// evaluator resolves acme addresses against a body in the same way
+// acme's addr.c does
+type evaluator struct {
+	body []rune
+	lim  Range
+	addr []rune
+	err  error
+}
+
+// Eval resolves addr relative to dot in body
+func Eval(body []rune, dot Range, addr string) (Range, error) {
+	return EvalLimit(body, Range{0, len(body)}, dot, addr)
+}
+
+// EvalLimit resolves addr relative to dot in body, restricting regular
+// expression searches to lim as acme does once limit=addr is written
+// to the ctl file
+func EvalLimit(body []rune, lim, dot Range, addr string) (Range, error) {
+	e := &evaluator{
+		body: body,
+		lim:  lim,
+		addr: []rune(addr),
+	}
+	r, q := e.address(dot, 0)
+	if q < len(e.addr) {
+		return dot, ErrSyntax
+	}
+	if e.err != nil {
+		return dot, e.err
+	}
+	return r, nil
+}
+
+func (e *evaluator) address(ar Range, q0 int) (Range, int) {
+	r := ar
+	q := q0
+	dir := dirNone
+	char := false
+	var c, prevc rune
+	for q < len(e.addr) {
+		prevc = c
+		c = e.addr[q]
+		q++
+		switch {
+		case c == ';' || c == ',':
+			if c == ';' {
+				ar = r
+			}
+			if prevc == 0 {
+				// lhs defaults to 0
+				r.Q0 = 0
+			}
+			if q >= len(e.addr) {
+				// rhs defaults to $
+				r.Q1 = len(e.body)
+			} else {
+				var nr Range
+				nr, q = e.address(ar, q)
+				r.Q1 = nr.Q1
+			}
+			if r.Q1 < r.Q0 && e.err == nil {
+				e.err = ErrOrder
+			}
+			return r, q
+		case c == '+' || c == '-':
+			if prevc == '+' || prevc == '-' {
+				if q >= len(e.addr) || (e.addr[q] != '#' && e.addr[q] != '/' && e.addr[q] != '?') {
+					r = e.number(r, 1, int(prevc), false)
+				}
+			}
+			dir = int(c)
+		case c == '.' || c == '$':
+			if q != q0+1 {
+				return r, q - 1
+			}
+			if c == '.' {
+				r = ar
+			} else {
+				r = Range{len(e.body), len(e.body)}
+			}
+			if q < len(e.addr) {
+				dir = dirFore
+			} else {
+				dir = dirNone
+			}
+		case c == '#' || isdigit(c):
+			if c == '#' {
+				if q == len(e.addr) || !isdigit(e.addr[q]) {
+					return r, q - 1
+				}
+				char = true
+				c = e.addr[q]
+				q++
+			}
+			n := int(c - '0')
+			for q < len(e.addr) && isdigit(e.addr[q]) {
+				n = n*10 + int(e.addr[q]-'0')
+				q++
+			}
+			r = e.number(r, n, dir, char)
+			dir = dirNone
+			char = false
+		case c == '/' || c == '?':
+			if c == '?' {
+				dir = dirBack
+			}
+			var pat []rune
+		loop:
+			for q < len(e.addr) {
+				c2 := e.addr[q]
+				q++
+				switch c2 {
+				case '\n':
+					q--
+					break loop
+				case '\\':
+					if q < len(e.addr) && e.addr[q] == c {
+						c2 = e.addr[q]
+						q++
+					} else {
+						pat = append(pat, c2)
+						if q == len(e.addr) {
+							break loop
+						}
+						c2 = e.addr[q]
+						q++
+					}
+				case c:
+					break loop
+				}
+				pat = append(pat, c2)
+			}
+			r = e.regexp(r, string(pat), dir)
+			dir = dirNone
+			char = false
+		default:
+			return r, q - 1
+		}
+	}
+	if dir != dirNone {
+		r = e.number(r, 1, dir, false)
+	}
+	return r, q
+}
+
+func (e *evaluator) number(r Range, n, dir int, char bool) Range {
+	if e.err != nil {
+		return r
+	}
+	nc := len(e.body)
+	if char {
+		switch dir {
+		case dirFore:
+			n = r.Q1 + n
+		case dirBack:
+			if r.Q0 == 0 && n > 0 {
+				r.Q0 = nc
+			}
+			n = r.Q0 - n
+		}
+		if n < 0 || n > nc {
+			e.err = ErrRange
+			return r
+		}
+		return Range{n, n}
+	}
+
+	q0, q1 := r.Q0, r.Q1
+	switch dir {
+	case dirNone:
+		q0, q1 = 0, 0
+		q0, q1, n = e.forward(q0, q1, n)
+	case dirFore:
+		if q1 > 0 {
+			for q1 < nc && e.body[q1-1] != '\n' {
+				q1++
+			}
+		}
+		q0 = q1
+		q0, q1, n = e.forward(q0, q1, n)
+	case dirBack:
+		if q0 < nc {
+			for q0 > 0 && e.body[q0-1] != '\n' {
+				q0--
+			}
+		}
+		q1 = q0
+		for n > 0 && q0 > 0 {
+			if e.body[q0-1] == '\n' {
+				n--
+				if n >= 0 {
+					q1 = q0
+				}
+			}
+			q0--
+		}
+		// :1-1 is :0 = #0, but :1-2 is an error
+		if n > 1 {
+			e.err = ErrRange
+			return r
+		}
+		n = 0
+		for q0 > 0 && e.body[q0-1] != '\n' {
+			q0--
+		}
+	}
+	if n > 0 {
+		e.err = ErrRange
+		return r
+	}
+	return Range{q0, q1}
+}
+
+func (e *evaluator) forward(q0, q1, n int) (int, int, int) {
+	nc := len(e.body)
+	for n > 0 && q1 < nc {
+		c := e.body[q1]
+		q1++
+		if c == '\n' || q1 == nc {
+			n--
+			if n > 0 {
+				q0 = q1
+			}
+		}
+	}
+	return q0, q1, n
+}
+
+func (e *evaluator) regexp(r Range, pat string, dir int) Range {
+	if e.err != nil {
+		return r
+	}
+	re, err := Compile(pat)
+	if err != nil {
+		e.err = err
+		return r
+	}
+	lim := e.lim
+	if lim.Q1 > len(e.body) {
+		lim.Q1 = len(e.body)
+	}
+	if lim.Q0 > lim.Q1 {
+		lim.Q0 = lim.Q1
+	}
+	var m Range
+	var ok bool
+	if dir == dirBack {
+		m, ok = Prev(re, e.body, lim, r.Q0)
+	} else {
+		m, ok = Next(re, e.body, lim, r.Q1)
+	}
+	if !ok {
+		e.err = ErrNoMatch
+		return r
+	}
+	return m
+}
+
+// Compile compiles an acme regular expression, in which ^ and $ match
+// at the start and end of each line
+func Compile(pat string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?m)" + pat)
+}
+
+// Next finds the first match of re in lim starting at or after q,
+// wrapping around to the start of lim if there is none
+func Next(re *regexp.Regexp, body []rune, lim Range, q int) (Range, bool) {
+	if q < lim.Q0 || q > lim.Q1 {
+		q = lim.Q0
+	}
+	if m, ok := matchFrom(re, body, lim, q); ok {
+		return m, true
+	}
+	return matchFrom(re, body, lim, lim.Q0)
+}
+
+// Prev finds the match of re in lim ending closest before q, wrapping
+// around to the end of lim if there is none
+func Prev(re *regexp.Regexp, body []rune, lim Range, q int) (Range, bool) {
+	all := All(re, body, lim)
+	if len(all) == 0 {
+		return Range{}, false
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].Q1 <= q {
+			return all[i], true
+		}
+	}
+	return all[len(all)-1], true
+}
+
+// matchFrom returns the leftmost match that starts at or after q. The
+// rune before q is kept in the subject so that ^ and \b see the same
+// context they would see in the whole body.
+func matchFrom(re *regexp.Regexp, body []rune, lim Range, q int) (Range, bool) {
+	if q == lim.Q0 {
+		s := string(body[lim.Q0:lim.Q1])
+		loc := re.FindStringIndex(s)
+		if loc == nil {
+			return Range{}, false
+		}
+		return toRange(s, loc, lim.Q0), true
+	}
+	s := string(body[q-1 : lim.Q1])
+	ctx, err := regexp.Compile("(?m)(?s:.)(" + re.String() + ")")
+	if err != nil {
+		return Range{}, false
+	}
+	loc := ctx.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return Range{}, false
+	}
+	return toRange(s, loc[2:4], q-1), true
+}
+
+// All returns every match of re in lim
+func All(re *regexp.Regexp, body []rune, lim Range) []Range {
+	s := string(body[lim.Q0:lim.Q1])
+	var all []Range
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		all = append(all, toRange(s, loc, lim.Q0))
+	}
+	return all
+}
+
+// toRange converts byte offsets into s to rune offsets into the body,
+// where s begins at rune offset base
+func toRange(s string, loc []int, base int) Range {
+	q0 := base + len([]rune(s[:loc[0]]))
+	q1 := q0 + len([]rune(s[loc[0]:loc[1]]))
+	return Range{q0, q1}
+}
+
+func isdigit(c rune) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,75 @@
+package addr
+
+import "testing"
+
+func TestEval(t *testing.T) {
+	body := []rune("one\ntwo\n\nfour\n")
+	testCases := []struct {
+		dot      Range
+		addr     string
+		expected Range
+	}{
+		{Range{0, 0}, ",", Range{0, 14}},
+		{Range{0, 0}, "$", Range{14, 14}},
+		{Range{0, 0}, "2", Range{4, 8}},
+		{Range{0, 0}, "#5", Range{5, 5}},
+		{Range{5, 5}, "-+", Range{4, 8}},
+		{Range{5, 5}, "-1;#5", Range{0, 5}},
+		{Range{5, 5}, "-/^/;+2", Range{4, 9}},
+		{Range{5, 5}, "+/^$/", Range{8, 8}},
+		{Range{10, 10}, "-/^$/", Range{8, 8}},
+		{Range{5, 5}, "#5;+#1", Range{5, 6}},
+		{Range{0, 0}, "/four/", Range{9, 13}},
+		{Range{0, 0}, "0", Range{0, 0}},
+		{Range{4, 6}, ".", Range{4, 6}},
+		{Range{4, 6}, ".,$", Range{4, 14}},
+		{Range{13, 13}, "?o?", Range{10, 11}},
+		{Range{0, 0}, "2,3", Range{4, 9}},
+		{Range{0, 0}, "/t.o/+", Range{8, 9}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.addr, func(t *testing.T) {
+			r, err := Eval(body, tc.dot, tc.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, r)
+			}
+		})
+	}
+}
+
+func TestEvalLimit(t *testing.T) {
+	body := []rune("one\ntwo\none\n")
+	r, err := EvalLimit(body, Range{4, 12}, Range{4, 4}, "/one/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Range{8, 11}); r != expected {
+		t.Fatalf("expected %v, got %v", expected, r)
+	}
+	if _, err := EvalLimit(body, Range{4, 8}, Range{4, 4}, "/one/"); err != ErrNoMatch {
+		t.Fatalf("expected %v, got %v", ErrNoMatch, err)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	body := []rune("one\n")
+	testCases := []struct {
+		addr     string
+		expected error
+	}{
+		{"#10", ErrRange},
+		{"5", ErrRange},
+		{"/none/", ErrNoMatch},
+		{"x", ErrSyntax},
+		{"#3,#1", ErrOrder},
+		{"#3;-#2", ErrOrder},
+	}
+	for _, tc := range testCases {
+		if _, err := Eval(body, Range{}, tc.addr); err != tc.expected {
+			t.Fatalf("expected %v for %q, got %v", tc.expected, tc.addr, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package addr
+
+import "fmt"
+
+// Line returns the range of line n of body, including its newline.
+// Lines count from 1.
+func Line(body []rune, n int) (Range, error) {
+	return Lines(body, n, n)
+}
+
+// Lines returns the range of the lines from and to of body, including
+// the newline of the last line
+func Lines(body []rune, from, to int) (Range, error) {
+	if from < 1 || to < from {
+		return Range{}, ErrRange
+	}
+	return Eval(body, Range{}, fmt.Sprintf("%d,%d", from, to))
+}
+
+// LineCol returns the line of the rune offset q in body and the visual
+// column of q within it. Tabs advance the column to the next multiple
+// of tabwidth. Lines and columns count from 1.
+func LineCol(body []rune, q, tabwidth int) (line, col int) {
+	if q > len(body) {
+		q = len(body)
+	}
+	line = 1
+	start := 0
+	for i, c := range body[:q] {
+		if c == '\n' {
+			line++
+			start = i + 1
+		}
+	}
+	return line, Width(body[start:q], tabwidth) + 1
+}
+
+// Offset returns the rune offset of the visual column col on line n
+// of body
+func Offset(body []rune, line, col, tabwidth int) (int, error) {
+	r, err := Line(body, line)
+	if err != nil {
+		return 0, err
+	}
+	return r.Q0 + Column(body[r.Q0:r.Q1], col, tabwidth), nil
+}
+
+// Width returns the number of columns text takes up when it starts
+// at the beginning of a line
+func Width(text []rune, tabwidth int) int {
+	w := 0
+	for _, c := range text {
+		w = advance(w, c, tabwidth)
+	}
+	return w
+}
+
+// Column returns the index of the rune in line at the visual column
+// col. Columns within a tab are moved to the tab and columns past the
+// end of the line to its newline.
+func Column(line []rune, col, tabwidth int) int {
+	w := 0
+	for i, c := range line {
+		if c == '\n' {
+			return i
+		}
+		w = advance(w, c, tabwidth)
+		if col-1 < w {
+			return i
+		}
+	}
+	return len(line)
+}
+
+func advance(w int, c rune, tabwidth int) int {
+	if c == '\t' && tabwidth > 0 {
+		return w + tabwidth - w%tabwidth
+	}
+	return w + 1
+}
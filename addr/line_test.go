@@ -0,0 +1,77 @@
+package addr
+
+import "testing"
+
+func TestLineCol(t *testing.T) {
+	body := []rune("one\n\ttwö\n  \tx\n")
+	testCases := []struct {
+		q, line, col int
+	}{
+		{0, 1, 1},
+		{3, 1, 4},
+		{4, 2, 1},
+		{5, 2, 9},
+		{7, 2, 11},
+		{11, 3, 3},
+		{12, 3, 9},
+		{13, 3, 10},
+		{14, 4, 1},
+	}
+	for _, tc := range testCases {
+		line, col := LineCol(body, tc.q, 8)
+		if line != tc.line || col != tc.col {
+			t.Fatalf("expected #%d at %d:%d, got %d:%d", tc.q, tc.line, tc.col, line, col)
+		}
+		if tc.line == 4 {
+			continue
+		}
+		q, err := Offset(body, line, col, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q != tc.q {
+			t.Fatalf("expected %d:%d at #%d, got #%d", line, col, tc.q, q)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	body := []rune("\tab\nc\n")
+	testCases := []struct {
+		line, col, expected int
+	}{
+		// within the tab
+		{1, 4, 0},
+		{1, 5, 1},
+		{1, 6, 2},
+		// past the end of the line
+		{1, 20, 3},
+		{2, 9, 5},
+	}
+	for _, tc := range testCases {
+		q, err := Offset(body, tc.line, tc.col, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q != tc.expected {
+			t.Fatalf("expected %d:%d at #%d, got #%d", tc.line, tc.col, tc.expected, q)
+		}
+	}
+	if _, err := Offset(body, 5, 1, 4); err != ErrRange {
+		t.Fatalf("expected %v, got %v", ErrRange, err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	body := []rune("one\ntwo\nthree")
+	r, err := Lines(body, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Range{4, 13}); r != expected {
+		t.Fatalf("expected %v, got %v", expected, r)
+	}
+	if _, err := Line(body, 0); err != ErrRange {
+		t.Fatalf("expected %v, got %v", ErrRange, err)
+	}
+}
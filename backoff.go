@@ -0,0 +1,58 @@
+package nyne
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff is the delay between attempts to reconnect to acme. The
+// delay starts at Min and doubles after every failed attempt up to
+// Max.
+type Backoff struct {
+	Min, Max time.Duration
+}
+
+// DefaultBackoff is the Backoff used by nyne
+var DefaultBackoff = Backoff{
+	Min: 100 * time.Millisecond,
+	Max: 5 * time.Second,
+}
+
+// delays returns the first and the largest delay, defaulting each to
+// DefaultBackoff when it is not set. Max is never less than Min.
+func (b *Backoff) delays() (min, max time.Duration) {
+	min, max = b.Min, b.Max
+	if min <= 0 {
+		min = DefaultBackoff.Min
+	}
+	if max <= 0 {
+		max = DefaultBackoff.Max
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
+// wait blocks until acme accepts connections again or ctx is done
+func (b *Backoff) wait(ctx context.Context) error {
+	delay, max := b.delays()
+	for {
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		c, err := dial()
+		if err == nil {
+			c.Close()
+			return nil
+		}
+		delay *= 2
+		if delay > max {
+			delay = max
+		}
+	}
+}
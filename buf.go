@@ -1,28 +1,57 @@
 package nyne
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
 	"unicode/utf8"
 )
 
 // Buf implements the BufListener interface and runs on opened
 // acme buffers
 type Buf struct {
-	id         int
-	file       string
-	win        *Win
+	id        int
+	file      string
+	win       Window
+	lastpoint int
+	ctx       context.Context
+	mu        sync.Mutex
+	shadow    *Shadow
+	// Open opens the window when the Buf is started
+	Open WinOpener
+	// Shadow keeps a copy of the body in memory for Body
+	Shadow bool
+	// Pool bounds the write hooks running at once across Bufs. Any
+	// number run at once if it is nil.
+	Pool *Pool
+	// HookTimeout is how long a write hook or a window hook may run
+	// before it is reported to Errors and the next one is started.
+	// Hooks are not timed out if it is zero.
+	HookTimeout time.Duration
+	// Errors receives the write hooks and window hooks that time out
+	Errors     ErrorHandler
 	EventHooks map[Text][]Handler
 	WinHooks   map[Text][]WinHandler
 	KeyHooks   map[rune]Handler
+	// Router runs after the hooks in the maps when it is set
+	Router *Router
+	// Trace receives each event read and the result of each hook
+	// run on it when it is set
+	Trace Tracer
 }
 
 // NewBuf constructs an event loop
 func NewBuf(id int, file string) *Buf {
 	return &Buf{
-		id:         id,
-		file:       file,
-		EventHooks: make(map[Text][]Handler),
-		WinHooks:   make(map[Text][]WinHandler),
-		KeyHooks:   make(map[rune]Handler),
+		id:          id,
+		file:        file,
+		Open:        OpenWindow,
+		HookTimeout: DefaultHookTimeout,
+		Errors:      StderrErrors,
+		EventHooks:  make(map[Text][]Handler),
+		WinHooks:    make(map[Text][]WinHandler),
+		KeyHooks:    make(map[rune]Handler),
 	}
 }
 
@@ -32,36 +61,99 @@ func (b *Buf) File() string {
 }
 
 //Win returns the active acme Window
-func (b *Buf) Win() *Win {
+func (b *Buf) Win() Window {
 	return b.win
 }
 
+// Body returns the text of the window's body. When Shadow is set the
+// text is read from memory and reflects the events read before the
+// running hook, but not edits the hook has made itself.
+func (b *Buf) Body() ([]byte, error) {
+	if b.shadow != nil {
+		return b.shadow.Bytes(), nil
+	}
+	return b.win.Body()
+}
+
+// Context returns the context the Buf was started with. Write hooks
+// should use the context of the Event they are given instead, which is
+// also done when the hook times out.
+func (b *Buf) Context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
 // Start begins the event listener for the window
 func (b *Buf) Start() error {
-	w, err := OpenWin(b.id, b.file)
+	return b.StartContext(context.Background())
+}
+
+// StartContext begins the event listener for the window. When ctx is
+// done the window's files are closed and ctx.Err() is returned.
+//
+// Window hooks and write hooks run on a queue of their own so that
+// events, and typing in particular, are handled while they run. The
+// queue runs them one at a time in the order of their events, so the
+// edits they make to the window stay ordered.
+func (b *Buf) StartContext(ctx context.Context) error {
+	w, err := b.Open(b.id, b.file)
 	if err != nil {
 		return err
 	}
 	b.win = w
+	b.ctx = ctx
+	defer b.win.Close()
+	if b.Shadow {
+		body, err := b.win.Body()
+		if err != nil {
+			return err
+		}
+		b.shadow = NewShadow(body)
+	}
+
+	// the queue is stopped before the window is closed
+	var running sync.WaitGroup
+	defer running.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	q := newQueue()
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		q.run(ctx)
+	}()
+	hookErrs := make(chan error, 1)
 
 	// runs hooks for acme 'new' event
-	go b.winEvent(b.win, Event{Text: New})
+	q.push(func() {
+		event := Event{ID: b.id, File: b.file, Text: New}
+		b.Trace.trace(Trace{Event: event})
+		b.winEvent(event)
+	})
 	stop := make(chan struct{})
-	defer func() { stop <- struct{}{} }()
-	events, errs := b.win.EventChan(b.id, b.file, stop)
+	defer close(stop)
+	events, errs := b.win.EventChan(stop)
 	for {
 		select {
 		case event, ok := <-events:
 			if !ok {
+				// the window was deleted
 				return nil
 			}
+			b.Trace.trace(Trace{Event: event})
+			if b.shadow != nil {
+				if err := b.track(event); err != nil {
+					return err
+				}
+			}
 			if event.Origin == Keyboard && event.Action == BodyInsert {
-				w.Lastpoint = event.SelBegin
+				b.setPoint(event.SelBegin)
 				event, ok = b.keyEvent(event)
 			} else {
 				if event.Origin == DelOrigin && event.Action == DelAction {
 					b.win.WriteEvent(event)
-					b.win.Close()
 					return nil
 				}
 				event, ok = b.execEvent(event)
@@ -71,61 +163,356 @@ func (b *Buf) Start() error {
 			}
 
 			b.win.WriteEvent(event)
-			for _, h := range event.WriteHooks {
-				if err := h(event); err != nil {
-					return err
-				}
+			if len(event.WriteHooks) == 0 && event.Text != Put {
+				continue
 			}
-
-			// maintain current address after formatting buffer
-			if event.Text == Put {
-				body, err := b.win.Body()
-				if err != nil {
-					return err
-				}
-				if len(body) < w.Lastpoint {
-					w.Lastpoint = len(body)
-				}
-				if err := b.win.SetAddr("#%d", w.Lastpoint); err != nil {
-					return err
-				}
-				if err := b.win.SelectionFromAddr(); err != nil {
-					return err
-				}
-				if err := b.win.Show(); err != nil {
-					return err
+			q.push(func() {
+				if err := b.writeHooks(ctx, event); err != nil {
+					select {
+					case hookErrs <- err:
+					default:
+					}
 				}
-			}
+			})
+		case err := <-hookErrs:
+			return err
 		case err := <-errs:
 			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// writeHooks runs the write hooks of the event and then restores the
+// address the window had before it was formatted
+func (b *Buf) writeHooks(ctx context.Context, event Event) error {
+	for i, h := range event.WriteHooks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := b.runHook(ctx, event, writeName(event, i), h); err != nil {
+			return err
+		}
+	}
+
+	// maintain current address after formatting buffer
+	if event.Text != Put {
+		return nil
+	}
+	n, err := b.runeCount()
+	if err != nil {
+		return err
+	}
+	q := b.point()
+	if n < q {
+		q = n
+		b.setPoint(q)
+	}
+	b.win.Lock()
+	defer b.win.Unlock()
+	if err := b.win.SetAddr("#%d", q); err != nil {
+		// the shadow has not seen the events of the edits yet
+		if b.shadow == nil {
+			return err
+		}
+		if err := b.win.SetAddr("$"); err != nil {
+			return err
 		}
 	}
+	if err := b.win.SelectionFromAddr(); err != nil {
+		return err
+	}
+	return b.win.Show()
 }
 
-func (b *Buf) winEvent(w *Win, event Event) {
-	for _, hook := range b.WinHooks[event.Text] {
-		hook(w)
+// runHook runs the write hook in a slot of the Pool with a context of
+// its own. A hook that runs past HookTimeout is reported to Errors and
+// its context is cancelled, and the next hook is started without
+// waiting for it. Hooks check their event's context before editing
+// the window, so that the edits of a late hook are dropped.
+func (b *Buf) runHook(ctx context.Context, event Event, name string, h Hook) error {
+	if err := b.Pool.acquire(ctx); err != nil {
+		return err
+	}
+	defer b.Pool.release()
+	hctx, cancel := ctx, context.CancelFunc(func() {})
+	if b.HookTimeout > 0 {
+		hctx, cancel = context.WithTimeout(ctx, b.HookTimeout)
+	}
+	defer cancel()
+	event.ctx = hctx
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- h(event)
+	}()
+	select {
+	case err := <-done:
+		b.Trace.trace(Trace{Event: event, Hook: name, Err: err, Took: time.Since(start)})
+		return err
+	case <-hctx.Done():
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	b.timedOut(event, name, start)
+	return nil
+}
+
+// runWin runs the window hook with the same timeout as write hooks. The
+// hook is given a fenced window, so that it cannot edit the window
+// once it has timed out.
+func (b *Buf) runWin(event Event, name string, h WinHandler) {
+	// the window is only fenced off when the hook times out, so
+	// that a hook still running when the Buf stops keeps its edits
+	fence, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &fenced{Window: b.win, ctx: fence}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Trace.win(name, h, w, event)
+	}()
+	if b.HookTimeout <= 0 {
+		<-done
+		return
+	}
+	t := time.NewTimer(b.HookTimeout)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		cancel()
+		b.timedOut(event, name, start)
+	}
+}
+
+// timedOut fences off the hook whose context has been cancelled and
+// reports it to Errors. The window is locked and unlocked so that a
+// sequence of edits the hook started before it timed out is finished
+// before the next hook runs.
+func (b *Buf) timedOut(event Event, name string, start time.Time) {
+	unlocked := make(chan struct{})
+	go func() {
+		b.win.Lock()
+		b.win.Unlock()
+		close(unlocked)
+	}()
+	t := time.NewTimer(b.HookTimeout)
+	select {
+	case <-unlocked:
+	case <-t.C:
+		// the hook is stuck holding the lock
+	}
+	t.Stop()
+	err := &HookError{
+		ID:   b.id,
+		File: b.file,
+		Hook: name,
+		Err:  fmt.Errorf("timed out after %v", b.HookTimeout),
+	}
+	b.Trace.trace(Trace{Event: event, Hook: name, Err: err.Err, Took: time.Since(start)})
+	if b.Errors != nil {
+		b.Errors(err)
+	} else {
+		StderrErrors(err)
+	}
+}
+
+// fenced is a Window whose edits fail once ctx is done, which is how
+// the edits of a hook that ran past its timeout are dropped
+type fenced struct {
+	Window
+	ctx context.Context
+}
+
+// WriteEvent writes the event back unless the window is fenced off
+func (f *fenced) WriteEvent(e Event) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
 	}
+	return f.Window.WriteEvent(e)
 }
 
+// Ctl writes the message unless the window is fenced off
+func (f *fenced) Ctl(format string, args ...interface{}) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.Ctl(format, args...)
+}
+
+// Exec executes the command unless the window is fenced off
+func (f *fenced) Exec(exec string, args ...string) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.Exec(exec, args...)
+}
+
+// SetAddr sets the address unless the window is fenced off
+func (f *fenced) SetAddr(fmtstr string, args ...interface{}) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.SetAddr(fmtstr, args...)
+}
+
+// AddrFromSelection sets addr to dot unless the window is fenced off
+func (f *fenced) AddrFromSelection() error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.AddrFromSelection()
+}
+
+// SelectionFromAddr sets dot to addr unless the window is fenced off
+func (f *fenced) SelectionFromAddr() error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.SelectionFromAddr()
+}
+
+// SetData writes the data unless the window is fenced off
+func (f *fenced) SetData(data []byte) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.SetData(data)
+}
+
+// AppendBody appends to the body unless the window is fenced off
+func (f *fenced) AppendBody(data []byte) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.AppendBody(data)
+}
+
+// ClearBody clears the body unless the window is fenced off
+func (f *fenced) ClearBody() error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.ClearBody()
+}
+
+// AppendTag writes to the tag unless the window is fenced off
+func (f *fenced) AppendTag(text string) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.AppendTag(text)
+}
+
+// ClearTag clears the tag unless the window is fenced off
+func (f *fenced) ClearTag() error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	return f.Window.ClearTag()
+}
+
+// runeCount returns the number of runes in the body, which is read
+// from the shadow when there is one
+func (b *Buf) runeCount() (int, error) {
+	if b.shadow != nil {
+		return b.shadow.Len(), nil
+	}
+	body, err := b.win.Body()
+	if err != nil {
+		return 0, err
+	}
+	return utf8.RuneCount(body), nil
+}
+
+func (b *Buf) point() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastpoint
+}
+
+func (b *Buf) setPoint(q int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastpoint = q
+}
+
+// track applies the change to the body reported by the event to the
+// shadow. Acme leaves the text out of long inserts, so the shadow is
+// read from the window again when one arrives.
+func (b *Buf) track(e Event) error {
+	var err error
+	switch e.Action {
+	case BodyInsert:
+		if len(e.Text) == 0 && e.SelEnd > e.SelBegin {
+			return b.resync()
+		}
+		err = b.shadow.Insert(e.SelBegin, []rune(string(e.Text)))
+	case BodyDelete:
+		err = b.shadow.Delete(e.SelBegin, e.SelEnd)
+	}
+	if err != nil {
+		// the shadow has drifted from the window
+		return b.resync()
+	}
+	return nil
+}
+
+func (b *Buf) resync() error {
+	body, err := b.win.Body()
+	if err != nil {
+		return err
+	}
+	b.shadow.Reset(body)
+	return nil
+}
+
+func (b *Buf) winEvent(event Event) {
+	for i, hook := range b.WinHooks[event.Text] {
+		b.runWin(event, fmt.Sprintf("hook %d", i), hook)
+	}
+	if b.Router != nil {
+		b.Router.win(event, func(name string, h WinHandler) {
+			b.runWin(event, name, h)
+		})
+	}
+}
+
+// keyEvent runs the hooks for typed text. The event is not written
+// back to acme if a hook stops the chain.
 func (b *Buf) keyEvent(event Event) (Event, bool) {
 	r, _ := utf8.DecodeRune(event.Text.Bytes())
-	hook, ok := b.KeyHooks[r]
-	if !ok {
-		return event, true
+	ok := true
+	if hook, found := b.KeyHooks[r]; found {
+		event, ok = b.Trace.event(fmt.Sprintf("key %q", r), hook, event)
 	}
-	return hook(event)
+	if ok && b.Router != nil {
+		event, ok = b.Router.event(event, b.Trace)
+	}
+	return event, ok
 }
 
+// execEvent runs the hooks for the event. The original event is
+// written back to acme if a hook stops the chain.
 func (b *Buf) execEvent(event Event) (Event, bool) {
 	origEvent := event
 	newEvent := origEvent
 	ok := true
-	for _, hook := range b.EventHooks[event.Text] {
-		newEvent, ok = hook(newEvent)
+	for i, hook := range b.EventHooks[event.Text] {
+		newEvent, ok = b.Trace.event(fmt.Sprintf("hook %d", i), hook, newEvent)
 		if !ok {
 			return origEvent, true
 		}
 	}
+	if b.Router != nil {
+		if newEvent, ok = b.Router.event(newEvent, b.Trace); !ok {
+			return origEvent, true
+		}
+	}
 	return newEvent, ok
 }
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/dnjp/nyne"
 )
@@ -74,49 +75,25 @@ func winid() (winid int, err error) {
 	return
 }
 
-func inout() (inw, outw *nyne.Win, wid int, name string, err error) {
-	var wins map[int]*nyne.Win
-	var ok bool
-
+func inout() (inw *nyne.Win, outw *nyne.Output, wid int, name string, err error) {
 	wid, err = winid()
 	if err != nil {
 		return
 	}
 
-	wins, err = nyne.Windows()
+	wins, err := nyne.Windows()
 	if err != nil {
 		return
 	}
 
-	inw, ok = wins[wid]
+	inw, ok := wins[wid]
 	if !ok {
 		err = fmt.Errorf("could not find window with id %d", wid)
 		return
 	}
 
-	dir := filepath.Dir(inw.File)
-	name = dir + "/-spell"
-
-	for _, win := range wins {
-		if win.File == name {
-			outw = win
-			err = outw.ClearBody()
-			if err != nil {
-				return
-			}
-		}
-	}
-	if outw == nil {
-		outw, err = nyne.NewWin()
-		if err != nil {
-			return
-		}
-
-		err = outw.Name(name)
-		if err != nil {
-			return
-		}
-	}
+	name = filepath.Dir(inw.File()) + "/-spell"
+	outw, err = nyne.OutputWin(name, nyne.OutputOptions{Mode: nyne.OutputClear})
 	return
 }
 
@@ -163,13 +140,13 @@ func main() {
 			if len(word) == 0 {
 				continue
 			}
-			idx := bytes.Index(line, word) + q0
-			addr := inw.File + fmt.Sprintf(":#%d:%s", idx, string(word))
+			idx := nyne.RuneOffset(line, bytes.Index(line, word)) + q0
+			addr := inw.File() + fmt.Sprintf(":#%d:%s", idx, string(word))
 			corrections[idx] = addr
 			addrs = append(addrs, idx)
 		}
 		lastnl = i + 1
-		q0 += len(line)
+		q0 += utf8.RuneCount(line)
 	}
 
 	sort.Ints(addrs)
@@ -186,15 +163,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	err = outw.SetAddr("#0")
-	if err != nil {
-		panic(err)
-	}
-	err = outw.SelectionFromAddr()
-	if err != nil {
-		panic(err)
-	}
-	if err := outw.Show(); err != nil {
+	if err := outw.Top(); err != nil {
 		panic(err)
 	}
 }
@@ -47,8 +47,8 @@ func main() {
 	font := exec.Command(cmd)
 	font.Env = os.Environ()
 	font.Env = append(font.Env, fmt.Sprintf("winid=%d", winid))
-	font.Env = append(font.Env, fmt.Sprintf("%%=%s", w.File))
-	font.Env = append(font.Env, fmt.Sprintf("samfile=%s", w.File))
+	font.Env = append(font.Env, fmt.Sprintf("%%=%s", w.File()))
+	font.Env = append(font.Env, fmt.Sprintf("samfile=%s", w.File()))
 	err = font.Run()
 	if err != nil {
 		panic(err)
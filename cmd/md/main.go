@@ -15,6 +15,7 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/dnjp/nyne"
 )
@@ -38,19 +39,24 @@ func update(w *nyne.Win, cb func(w *nyne.Win, q0, q1 int) (nq0, nq1, curs int, o
 	}
 
 	nq0, nq1, curs, out := cb(w, q0, q1)
-	err = w.SetAddr("#%d;#%d", nq0, nq1)
-	if err != nil {
-		panic(err)
-	}
-
-	w.SetData(out)
-
-	err = w.SetAddr("#%d", curs)
-	if err != nil {
-		panic(err)
-	}
-
-	err = w.SelectionFromAddr()
+	err = w.Transaction(func(w *nyne.Win) error {
+		err := w.SetAddr("#%d;#%d", nq0, nq1)
+		if err != nil {
+			return err
+		}
+
+		err = w.SetData(out)
+		if err != nil {
+			return err
+		}
+
+		err = w.SetAddr("#%d", curs)
+		if err != nil {
+			return err
+		}
+
+		return w.SelectionFromAddr()
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -81,7 +87,7 @@ func link(w *nyne.Win, q0, q1 int) (nq0, nq1, curs int, out []byte) {
 		out = []byte("[")
 		out = append(out, dat...)
 		out = append(out, "]()"...)
-		curs = q0 + len(out) - 1
+		curs = q0 + utf8.RuneCount(out) - 1
 	}
 	nq0 = q0
 	nq1 = q1
@@ -107,7 +113,7 @@ func bold(w *nyne.Win, q0, q1 int) (nq0, nq1, curs int, out []byte) {
 	out = []byte("*")
 	out = append(out, dat...)
 	out = append(out, "*"...)
-	curs = q0 + len(out)
+	curs = q0 + utf8.RuneCount(out)
 	nq0 = q0
 	nq1 = q1
 	return
@@ -132,15 +138,15 @@ func italic(w *nyne.Win, q0, q1 int) (nq0, nq1, curs int, out []byte) {
 	out = []byte("_")
 	out = append(out, dat...)
 	out = append(out, "_"...)
-	curs = q0 + len(out)
+	curs = q0 + utf8.RuneCount(out)
 	nq0 = q0
 	nq1 = q1
 	return
 }
 
 func preview(w *nyne.Win) {
-	outfile := strings.TrimSuffix(path.Base(w.File), ".md")
-	dir := path.Dir(w.File)
+	outfile := strings.TrimSuffix(path.Base(w.File()), ".md")
+	dir := path.Dir(w.File())
 	outpath := path.Join("/tmp/", outfile+".html")
 
 	var out bytes.Buffer
@@ -149,7 +155,7 @@ func preview(w *nyne.Win) {
 		"--metadata",
 		"title="+outfile,
 		"-s",
-		w.File,
+		w.File(),
 	)
 	tomd.Stdout = &out
 
@@ -198,8 +204,12 @@ func main() {
 		panic(fmt.Errorf("could not find window with id %d", winid))
 	}
 
-	ft, _ := nyne.FindFiletype(nyne.Filename(w.File))
-	if ft.Name != "markdown" {
+	kind, err := w.Kind()
+	if err != nil {
+		panic(err)
+	}
+	ft, _ := nyne.FindFiletype(nyne.Filename(w.File()))
+	if !kind.IsFile() || ft.Name != "markdown" {
 		return
 	}
 
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/dnjp/nyne"
+	"github.com/dnjp/nyne/acmetest"
+)
+
+var fsrv *acmetest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	fsrv, err = acmetest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	fsrv.Close()
+	os.Exit(code)
+}
+
+func TestUpdate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cb       func(w *nyne.Win, q0, q1 int) (nq0, nq1, curs int, out []byte)
+		body     string
+		q0, q1   int
+		expected string
+		curs     int
+	}{
+		{"bold", bold, "some text\n", 5, 10, "some *text*\n", 11},
+		{"italic", italic, "some text\n", 0, 4, "_some_ text\n", 6},
+		{"link", link, "see http://x\n", 4, 12, "see [](http://x)\n", 5},
+		{"empty link", link, "\n", 0, 0, "[]()\n", 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer fsrv.Reset()
+			fw := fsrv.NewWindow("/tmp/test.md", tc.body)
+			fw.SetDot(tc.q0, tc.q1)
+
+			w, err := nyne.OpenWin(fw.ID(), "/tmp/test.md")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			update(w, tc.cb)
+			if fw.Body() != tc.expected {
+				t.Fatalf("expected body %q, got %q", tc.expected, fw.Body())
+			}
+			if q0, q1 := fw.Dot(); q0 != tc.curs || q1 != tc.curs {
+				t.Fatalf("expected cursor at %d, got %d,%d", tc.curs, q0, q1)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"unicode"
 
 	"github.com/dnjp/nyne"
+	"github.com/dnjp/nyne/addr"
 )
 
 var direction = flag.String("d", "", "the direction to move: up, down, left, right, start, end")
@@ -23,12 +24,11 @@ var word = flag.Bool("w", false, "move by word (only valid for left and right)")
 var paragraph = flag.Bool("p", false, "move by paragraph (only valid for left and right)")
 var sel = flag.Bool("s", false, "select text while moving")
 
-func isword(c byte) bool {
-	r := rune(c)
-	return unicode.IsLetter(r) || unicode.IsDigit(r)
+func isword(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
 }
 
-func prevword(body []byte, tw, start, q int) (nq int) {
+func prevword(body []rune, tw, start, q int) (nq int) {
 	leftv := q - start
 	for i := leftv; i >= 0; i-- {
 		pc := body[i]
@@ -45,7 +45,7 @@ func prevword(body []byte, tw, start, q int) (nq int) {
 	return q
 }
 
-func nextword(body []byte, tw, start, q int) (nq int) {
+func nextword(body []rune, tw, start, q int) (nq int) {
 	leftv := q - start
 	length := len(body)
 	for i := leftv; i < length; i++ {
@@ -67,7 +67,7 @@ func nextword(body []byte, tw, start, q int) (nq int) {
 	return q
 }
 
-func left(body []byte, tw, start, q int) (nq int) {
+func left(body []rune, tw, start, q int) (nq int) {
 	nq = q - 1
 	if nq <= 0 {
 		return 0
@@ -75,14 +75,14 @@ func left(body []byte, tw, start, q int) (nq int) {
 	return nq
 }
 
-func right(body []byte, tw, start, q int) (nq int) {
+func right(body []rune, tw, start, q int) (nq int) {
 	return q + 1
 }
 
-func up(body []byte, tw, start, q int) (nq int) {
+func up(body []rune, tw, start, q int) (nq int) {
 	var (
 		i, nl, fromstart, starttabs, off int
-		c                                byte
+		c                                rune
 	)
 
 	// find fromstart
@@ -133,11 +133,11 @@ func up(body []byte, tw, start, q int) (nq int) {
 	return q
 }
 
-func down(body []byte, tw, start, q int) (nq int) {
+func down(body []rune, tw, start, q int) (nq int) {
 	var (
 		i, nl, starttabs, off int
 		hasc, hasc2, atq, set bool
-		c                     byte
+		c                     rune
 	)
 
 	fromstart := q - start
@@ -194,124 +194,127 @@ func down(body []byte, tw, start, q int) (nq int) {
 	return start + i
 }
 
-func blankline(w *nyne.Win, q int, up bool) (nq int) {
-	off := 1
-	regex := "+/^$/"
-	if up {
-		off = -1
-		regex = "-/^$/"
-	}
-	err := w.SetAddr("#%d", q+off)
+// buffer holds the lines around dot so that addresses can be
+// evaluated without a round trip to acme for each of them
+type buffer struct {
+	text []rune
+	// off is the offset of text in the body
+	off int
+	dot addr.Range
+}
+
+// load reads the lines from the one before dot to the one after it,
+// which are all the movements by character, word and line look at
+func load(w *nyne.Win) (*buffer, error) {
+	q0, q1, err := w.CurrentAddr()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	err = w.SetAddr(regex)
+	err = w.SetAddr("#%d-1;#%d+1", q0, q1)
 	if err != nil {
-		panic(err)
+		// there is no line after dot
+		err = w.SetAddr("#%d-1;$", q0)
 	}
-	nq, _, err = w.Addr()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return nq
-}
-
-func curline(w *nyne.Win, sel, incQ1 bool) (body []byte, start, q0, q1 int, err error) {
-	q0, q1, err = w.CurrentAddr()
+	r0, r1, err := w.Addr()
 	if err != nil {
-		return
+		return nil, err
 	}
-
-	if incQ1 {
-		err = w.SetAddr("#%d", q1)
-		if err != nil {
-			return
+	var text []byte
+	if r1 > r0 {
+		if text, err = w.Data(r0, r1); err != nil {
+			return nil, err
 		}
 	}
+	return &buffer{
+		text: []rune(string(text)),
+		off:  r0,
+		dot:  addr.Range{Q0: q0, Q1: q1},
+	}, nil
+}
 
-	err = w.SetAddr("-+")
+// eval evaluates the address relative to dot and returns its range in
+// the body
+func (b *buffer) eval(dot addr.Range, a string) (addr.Range, error) {
+	dot = addr.Range{Q0: dot.Q0 - b.off, Q1: dot.Q1 - b.off}
+	r, err := addr.Eval(b.text, dot, a)
 	if err != nil {
-		return
+		return addr.Range{}, err
 	}
+	return addr.Range{Q0: r.Q0 + b.off, Q1: r.Q1 + b.off}, nil
+}
 
-	var end int
-	start, end, err = w.Addr()
-	if err != nil {
-		return
-	}
+// slice returns the text between q0 and q1 of the body
+func (b *buffer) slice(q0, q1 int) []rune {
+	return b.text[q0-b.off : q1-b.off]
+}
 
-	body, err = w.Data(start, end)
+// lines returns the text addressed by a relative to dot
+func (b *buffer) lines(dot addr.Range, a string) (body []rune, start int, err error) {
+	r, err := b.eval(dot, a)
 	if err != nil {
-		return
+		return nil, 0, err
 	}
-	return
+	return b.slice(r.Q0, r.Q1), r.Q0, nil
 }
 
-func prevline(w *nyne.Win, sel bool) (body []byte, start, q0, q1 int, err error) {
-	q0, q1, err = w.CurrentAddr()
-	if err != nil {
-		return
+// blankline searches the window for the next or previous blank line.
+// Paragraphs can be any length, so the search is left to acme.
+func blankline(w *nyne.Win, q int, up bool) (nq int) {
+	off := 1
+	regex := "+/^$/"
+	if up {
+		off = -1
+		regex = "-/^$/"
 	}
-
-	err = w.SetAddr("-1;#%d", q0)
+	err := w.SetAddr("#%d", q+off)
 	if err != nil {
-		return
+		panic(err)
 	}
-
-	var end int
-	start, end, err = w.Addr()
+	err = w.SetAddr(regex)
 	if err != nil {
-		return
+		panic(err)
 	}
-
-	body, err = w.Data(start, end)
+	nq, _, err = w.Addr()
 	if err != nil {
-		return
+		panic(err)
 	}
-	return
+	return nq
 }
 
-func nextline(w *nyne.Win, sel bool) (body []byte, start, q0, q1 int, err error) {
-	q0, q1, err = w.CurrentAddr()
-	if err != nil {
-		return
-	}
-
-	if sel && q1 > q0 {
-		// must set addr to q1 so that the
-		// regex below will be in refernece
-		// to q1 instead of q0
-		err = w.SetAddr("#%d", q1)
-		if err != nil {
-			return
-		}
-	}
-
-	err = w.SetAddr("-/^/;+2")
-	if err != nil {
-		return
-	}
-
-	var end int
-	start, end, err = w.Addr()
-	if err != nil {
-		return
+func curline(b *buffer, sel, incQ1 bool) (body []rune, start, q0, q1 int, err error) {
+	q0, q1 = b.dot.Q0, b.dot.Q1
+	dot := b.dot
+	if incQ1 {
+		dot = addr.Range{Q0: q1, Q1: q1}
 	}
+	body, start, err = b.lines(dot, "-+")
+	return
+}
 
-	body, err = w.Data(start, end)
+func prevline(b *buffer, sel bool) (body []rune, start, q0, q1 int, err error) {
+	q0, q1 = b.dot.Q0, b.dot.Q1
+	r, err := b.eval(b.dot, "-1")
 	if err != nil {
 		return
 	}
+	start = r.Q0
+	body = b.slice(start, q0)
 	return
 }
 
-func tabwidth(w *nyne.Win) int {
-	tab, font, err := w.Font()
-	if err != nil {
-		panic(err)
+func nextline(b *buffer, sel bool) (body []rune, start, q0, q1 int, err error) {
+	q0, q1 = b.dot.Q0, b.dot.Q1
+	dot := b.dot
+	if sel && q1 > q0 {
+		// the regex below must be in reference to q1
+		// instead of q0
+		dot = addr.Range{Q0: q1, Q1: q1}
 	}
-	cw := font.StringWidth("0")
-	return tab / cw
+	body, start, err = b.lines(dot, "-/^/;+2")
+	return
 }
 
 func update(w *nyne.Win, sel bool, q0, q1 int) {
@@ -351,17 +354,24 @@ func main() {
 		panic(err)
 	}
 
-	tw := tabwidth(w)
+	tw, err := w.Tabwidth()
+	if err != nil {
+		panic(err)
+	}
+	b, err := load(w)
+	if err != nil {
+		panic(err)
+	}
 	switch strings.ToLower(*direction) {
 	case "up":
-		body, start, q0, q1, err := prevline(w, *sel)
+		body, start, q0, q1, err := prevline(b, *sel)
 		if err != nil {
 			panic(err)
 		}
 		q0 = up(body, tw, start, q0)
 		update(w, *sel, q0, q1)
 	case "down":
-		body, start, q0, q1, err := nextline(w, *sel)
+		body, start, q0, q1, err := nextline(b, *sel)
 		if err != nil {
 			panic(err)
 		}
@@ -372,17 +382,13 @@ func main() {
 		}
 		update(w, *sel, q0, q1)
 	case "left":
-		body, start, q0, q1, err := curline(w, *sel, false)
+		body, start, q0, q1, err := curline(b, *sel, false)
 		if err != nil {
 			panic(err)
 		}
 		if *word {
 			q0 = prevword(body, tw, start, q0)
 		} else if *paragraph {
-			q0, _, err = w.CurrentAddr()
-			if err != nil {
-				return
-			}
 			q0 = blankline(w, q0, true)
 		} else {
 			q0 = left(body, tw, start, q0)
@@ -393,7 +399,7 @@ func main() {
 		if *sel && *word {
 			incQ1 = true
 		}
-		body, start, q0, q1, err := curline(w, *sel, incQ1)
+		body, start, q0, q1, err := curline(b, *sel, incQ1)
 		if err != nil {
 			panic(err)
 		}
@@ -401,10 +407,6 @@ func main() {
 			if *word {
 				q1 = nextword(body, tw, start, q1)
 			} else if *paragraph {
-				_, q1, err = w.CurrentAddr()
-				if err != nil {
-					return
-				}
 				q1t := q1
 				q1 = blankline(w, q1, false)
 				if q1 < q1t {
@@ -417,10 +419,6 @@ func main() {
 		} else if *word {
 			q0 = nextword(body, tw, start, q0)
 		} else if *paragraph {
-			q0, _, err = w.CurrentAddr()
-			if err != nil {
-				return
-			}
 			q0t := q0
 			q0 = blankline(w, q0, false)
 			if q0 < q0t {
@@ -432,13 +430,13 @@ func main() {
 		}
 		update(w, *sel, q0, q1)
 	case "start":
-		_, start, _, q1, err := curline(w, *sel, false)
+		_, start, _, q1, err := curline(b, *sel, false)
 		if err != nil {
 			panic(err)
 		}
 		update(w, *sel, start, q1)
 	case "end":
-		body, start, q0, q1, err := curline(w, *sel, false)
+		body, start, q0, q1, err := curline(b, *sel, false)
 		if err != nil {
 			panic(err)
 		}
@@ -1,11 +1,50 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"testing"
+
+	"github.com/dnjp/nyne"
+	"github.com/dnjp/nyne/acmetest"
 )
 
+var fsrv *acmetest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	fsrv, err = acmetest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	fsrv.Close()
+	os.Exit(code)
+}
+
+func openWin(t *testing.T, body string, q0, q1 int) (*acmetest.Window, *nyne.Win) {
+	t.Helper()
+	fw := fsrv.NewWindow("/tmp/move.txt", body)
+	fw.SetDot(q0, q1)
+	w, err := nyne.OpenWin(fw.ID(), "/tmp/move.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fw, w
+}
+
+func loadBuf(t *testing.T, w *nyne.Win) *buffer {
+	t.Helper()
+	b, err := load(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
 func TestMovedown(t *testing.T) {
-	body := []byte(`	printf("hello world\n");
+	body := []rune(`	printf("hello world\n");
 printf("hello world\n");
 }
 `)
@@ -50,3 +89,94 @@ printf("hello world\n");
 		}
 	}
 }
+
+func TestCurline(t *testing.T) {
+	defer fsrv.Reset()
+	_, w := openWin(t, "one\ntwo\nthree\n", 5, 5)
+	defer w.Close()
+
+	body, start, q0, q1, err := curline(loadBuf(t, w), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "two\n" || start != 4 || q0 != 5 || q1 != 5 {
+		t.Fatalf("unexpected line %q start=%d q0=%d q1=%d", body, start, q0, q1)
+	}
+}
+
+func TestNextline(t *testing.T) {
+	defer fsrv.Reset()
+	fw, w := openWin(t, "one\ntwo\nthree\n", 1, 1)
+	defer w.Close()
+
+	body, start, q0, q1, err := nextline(loadBuf(t, w), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "one\ntwo\n" || start != 0 {
+		t.Fatalf("unexpected lines %q start=%d", body, start)
+	}
+	q0 = down(body, 8, start, q0)
+	update(w, false, q0, q1)
+	if q0, q1 := fw.Dot(); q0 != 5 || q1 != 5 {
+		t.Fatalf("expected cursor at 5, got %d,%d", q0, q1)
+	}
+	if fw.Shown() == 0 {
+		t.Fatal("expected the cursor to be shown")
+	}
+}
+
+func TestNextlineUnicode(t *testing.T) {
+	defer fsrv.Reset()
+	fw, w := openWin(t, "héllo\nwörld\n", 3, 3)
+	defer w.Close()
+
+	body, start, q0, q1, err := nextline(loadBuf(t, w), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "héllo\nwörld\n" {
+		t.Fatalf("unexpected lines %q", string(body))
+	}
+	q0 = down(body, 8, start, q0)
+	update(w, false, q0, q1)
+	if q0, q1 := fw.Dot(); q0 != 9 || q1 != 9 {
+		t.Fatalf("expected cursor at 9, got %d,%d", q0, q1)
+	}
+}
+
+func TestBlankline(t *testing.T) {
+	defer fsrv.Reset()
+	_, w := openWin(t, "one\ntwo\n\nfour\n\nsix\n", 0, 0)
+	defer w.Close()
+
+	if q := blankline(w, 1, false); q != 8 {
+		t.Fatalf("expected next blank line at 8, got %d", q)
+	}
+	if q := blankline(w, 10, true); q != 8 {
+		t.Fatalf("expected previous blank line at 8, got %d", q)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	defer fsrv.Reset()
+	testCases := []struct {
+		q0, q1   int
+		expected string
+		off      int
+	}{
+		{9, 9, "two\nthree\nfour\n", 4},
+		{9, 15, "two\nthree\nfour\nfive\n", 4},
+		{1, 1, "one\ntwo\n", 0},
+		{20, 20, "four\nfive\n", 14},
+	}
+	for _, tc := range testCases {
+		_, w := openWin(t, "one\ntwo\nthree\nfour\nfive\n", tc.q0, tc.q1)
+		b := loadBuf(t, w)
+		w.Close()
+		if string(b.text) != tc.expected || b.off != tc.off {
+			t.Fatalf("dot %d,%d: expected %q at %d, got %q at %d",
+				tc.q0, tc.q1, tc.expected, tc.off, string(b.text), b.off)
+		}
+	}
+}
@@ -2,12 +2,14 @@
 The core autoformatting engine that is run from within acme.
 
 	Usage of nyne:
-		nyne
+		nyne [-record dir]
+		nyne replay [-w winid] recording.jsonl
 
 Once you have built and installed nyne, simply execute `nyne` in
 acme by middle clicking on the text "nyne" typed in the upper most
-window tag. Nyne will watch for windows to be opened that match any
-of the extensions you have configured.  If it finds a match, it
+window tag. Nyne will attach to the windows that are already open
+and watch for new windows to be opened that match any of the
+extensions you have configured.  If it finds a match, it
 will write the menu options you've configured to the scratch area
 and begin listening for file save events received when you middle
 click `Put`. When this event is received, it will format the buffer
@@ -16,24 +18,152 @@ does not print to stdout, a new file will be written to `/tmp`,
 formatted using youc configured commands, and the output applied
 to your active buffer in acme. If `tabexpand` is enabled for a given
 file extension, `nynetab` will be used to convert tabs to spaces
-when you enter `tab` with your keyboard.
+when you enter `tab` with your keyboard. Directories, win terminals,
++Errors and other scratch windows are left alone, and WinRules in
+config.go can change how a window is classified.
+
+Errors from formatting and from the hooks nyne runs are written to
+the +Errors window of the file's directory, keeping the addresses
+printed by the formatters so that they can be opened with B3.
+
+If acme exits, nyne waits for it to be restarted and attaches to the
+new acme's windows. Nyne stops cleanly on SIGINT or SIGTERM, returning
+control of every window it is attached to back to acme.
+
+If $DEBUG is set, nyne opens a +nyne/trace window, again each time it
+reconnects to acme, and writes each event it reads to it, followed by
+the hooks that ran on the event, what each returned and how long each
+took.
+
+With -record, the events nyne reads from each window and the edits it
+makes are written to a file per window in dir. `nyne replay` plays a
+recording back through the formatting hooks, against an in-memory
+acme or against the window winid when -w is given, and prints where
+the resulting body differs from the recorded one, exiting with status
+1 if it does.
 
 */
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/dnjp/nyne"
+	"github.com/dnjp/nyne/acmetest"
 )
 
+var record = flag.String("record", "", "record the events and edits of each window to dir")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: nyne [-record dir]\n")
+	fmt.Fprintf(os.Stderr, "       nyne replay [-w winid] recording.jsonl\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
 func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.Arg(0) == "replay" {
+		if !replay(flag.Args()[1:]) {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() != 0 {
+		usage()
+	}
+
+	f, err := nyne.NewFormatter(nyne.Filetypes, nyne.Menu)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Record = *record
+	// keep running across acme restarts
+	f.Reconnect = &nyne.DefaultBackoff
+	f.Errors = nyne.AcmeErrors
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	err = f.RunContext(ctx)
+	if err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+}
+
+// replay replays the recording named in args and reports whether the
+// body matched the recorded one
+func replay(args []string) bool {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = usage
+	winid := fs.Int("w", 0, "replay against the acme window winid")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	records, err := nyne.ReadRecording(in)
+	in.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var file string
+	for _, rec := range records {
+		if rec.Op == nyne.RecordBody {
+			file = rec.File
+			break
+		}
+	}
+	if file == "" {
+		log.Fatalf("%s: recording has no initial body", fs.Arg(0))
+	}
+
+	id := *winid
+	if id == 0 {
+		srv, err := acmetest.Start()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer srv.Close()
+		id = srv.NewWindow(file, "").ID()
+	}
+	w, err := nyne.OpenWin(id, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+
 	f, err := nyne.NewFormatter(nyne.Filetypes, nyne.Menu)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = f.Run()
+	hunks, err := nyne.Replay(context.Background(), w, records, f.RunWindow)
 	if err != nil {
 		log.Fatal(err)
 	}
+	body, err := w.Body()
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := []rune(string(body))
+	for _, h := range hunks {
+		fmt.Printf("%s:#%d,#%d: got %q, want %q\n", file, h.Q0, h.Q1, string(r[h.Q0:h.Q1]), h.Text)
+	}
+	return len(hunks) == 0
 }
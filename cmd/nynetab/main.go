@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"unicode/utf8"
 
 	"github.com/dnjp/nyne"
 )
@@ -70,28 +71,33 @@ func main() {
 		com.Stdout = &out
 		com.Env = os.Environ()
 		com.Env = append(com.Env, fmt.Sprintf("winid=%d", winid))
-		com.Env = append(com.Env, fmt.Sprintf("%%=%s", w.File))
-		com.Env = append(com.Env, fmt.Sprintf("samfile=%s", w.File))
+		com.Env = append(com.Env, fmt.Sprintf("%%=%s", w.File()))
+		com.Env = append(com.Env, fmt.Sprintf("samfile=%s", w.File()))
 
 		err = com.Run()
 		if err != nil {
 			panic(err)
 		}
 
-		err = w.SetAddr("#%d;#%d", q0, q1)
-		if err != nil {
-			panic(err)
-		}
-
 		b := out.Bytes()
-		w.SetData(b)
+		err = w.Transaction(func(w *nyne.Win) error {
+			err := w.SetAddr("#%d;#%d", q0, q1)
+			if err != nil {
+				return err
+			}
 
-		err = w.SetAddr("#%d;#%d", q0, q0+len(b))
-		if err != nil {
-			panic(err)
-		}
+			err = w.SetData(b)
+			if err != nil {
+				return err
+			}
 
-		err = w.SelectionFromAddr()
+			err = w.SetAddr("#%d;#%d", q0, q0+utf8.RuneCount(b))
+			if err != nil {
+				return err
+			}
+
+			return w.SelectionFromAddr()
+		})
 		if err != nil {
 			panic(err)
 		}
@@ -99,6 +105,11 @@ func main() {
 		return
 	}
 
-	ft, _ := nyne.FindFiletype(nyne.Filename(w.File))
+	// tabs are only expanded in files, so that terminals still get
+	// a tab to complete with
+	var ft nyne.Filetype
+	if kind, err := w.Kind(); err == nil && kind.IsFile() {
+		ft, _ = nyne.FindFiletype(nyne.Filename(w.File()))
+	}
 	w.SetData(nyne.Tab(ft.Tabwidth, ft.Tabexpand))
 }
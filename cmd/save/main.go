@@ -9,22 +9,10 @@ package main
 import (
 	"fmt"
 	"os"
-	"path"
-	"strings"
 
 	"github.com/dnjp/nyne"
 )
 
-func isterm(w *nyne.Win) bool {
-	hostname, err := os.Hostname()
-	if err != nil {
-		panic(err)
-	}
-	_, file := path.Split(w.File)
-	file = strings.TrimPrefix(file, "-")
-	return strings.Contains(hostname, file)
-}
-
 func main() {
 	os.Unsetenv("winid") // do not trust the execution environment
 
@@ -43,8 +31,12 @@ func main() {
 		panic(fmt.Errorf("could not find window with id %d", winid))
 	}
 
-	// ignore terminal window
-	if isterm(w) {
+	// only files are written, not terminals or directories
+	kind, err := w.Kind()
+	if err != nil {
+		panic(err)
+	}
+	if !kind.IsFile() {
 		return
 	}
 
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"unicode/utf8"
 
 	"github.com/dnjp/nyne"
 )
@@ -33,6 +34,15 @@ func main() {
 		panic(fmt.Errorf("could not find window with id %d", winid))
 	}
 
+	// only source files are commented
+	kind, err := w.Kind()
+	if err != nil {
+		panic(err)
+	}
+	if !kind.IsFile() {
+		return
+	}
+
 	q0, q1, err := w.CurrentAddr()
 	if err != nil {
 		panic(err)
@@ -76,28 +86,33 @@ func main() {
 	com.Stdout = &out
 	com.Env = os.Environ()
 	com.Env = append(com.Env, fmt.Sprintf("winid=%d", winid))
-	com.Env = append(com.Env, fmt.Sprintf("%%=%s", w.File))
-	com.Env = append(com.Env, fmt.Sprintf("samfile=%s", w.File))
+	com.Env = append(com.Env, fmt.Sprintf("%%=%s", w.File()))
+	com.Env = append(com.Env, fmt.Sprintf("samfile=%s", w.File()))
 
 	err = com.Run()
 	if err != nil {
 		panic(err)
 	}
 
-	err = w.SetAddr("#%d;#%d", q0, q1)
-	if err != nil {
-		panic(err)
-	}
-
 	b := out.Bytes()
-	w.SetData(b)
+	err = w.Transaction(func(w *nyne.Win) error {
+		err := w.SetAddr("#%d;#%d", q0, q1)
+		if err != nil {
+			return err
+		}
 
-	err = w.SetAddr("#%d;#%d", q0, q0+len(b))
-	if err != nil {
-		panic(err)
-	}
+		err = w.SetData(b)
+		if err != nil {
+			return err
+		}
+
+		err = w.SetAddr("#%d;#%d", q0, q0+utf8.RuneCount(b))
+		if err != nil {
+			return err
+		}
 
-	err = w.SelectionFromAddr()
+		return w.SelectionFromAddr()
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -7,6 +7,7 @@ Execute a command in the focused window as if it had been clicked with B2
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -29,17 +30,16 @@ func main() {
 		panic(err)
 	}
 
-	wins, err := nyne.Windows()
+	w, err := nyne.OpenWin(winid, "")
 	if err != nil {
 		panic(err)
 	}
 
-	w, ok := wins[winid]
-	if !ok {
-		panic(fmt.Errorf("could not find window with id %d", winid))
-	}
-
 	err = w.Exec(cmd, args...)
+	if errors.Is(err, nyne.ErrNotRun) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
 	if err != nil {
 		panic(err)
 	}
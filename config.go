@@ -32,6 +32,12 @@ var Menu = []string{
 	"|com  ", "|a-  ", "|a+  ", "Ldef  ", "Lrefs  ", "Lcomp",
 }
 
+// WinRules override the kind Classify gives the windows they match,
+// such as the windows of tools that look like files
+var WinRules = []WinRule{
+	{Pattern: "*xplor*", Kind: WinScratch},
+}
+
 // Config maps file extensions to their formatting specification
 var Config = func() map[string]Filetype {
 	c := make(map[string]Filetype)
@@ -0,0 +1,346 @@
+package nyne
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"9fans.net/go/acme"
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+)
+
+// eventSize is the largest number of runes acme includes in an event
+const eventSize = 256
+
+// conn is a connection to acme. Windows are opened over nyne's own
+// connections rather than with the 9fans acme package, which mounts
+// acme once per process and keeps using that mount after acme exits,
+// so nothing opened through it works again once acme is restarted.
+// The package's Event type is still used for the event messages.
+//
+// Files that block on reads, like the log and event files, are read
+// over their own conn so that closing it interrupts the read.
+type conn struct {
+	c    *client.Conn
+	fsys *client.Fsys
+}
+
+// dial connects to the acme file server
+func dial() (*conn, error) {
+	c, err := client.DialService("acme")
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := c.Attach(nil, os.Getenv("USER"), "")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &conn{c: c, fsys: fsys}, nil
+}
+
+var (
+	mountMu sync.Mutex
+	mounted *conn
+)
+
+// mount returns the connection shared by windows, dialing acme if
+// there is none. Unlike the mount of the 9fans acme package it is
+// dialed again after unmount.
+func mount() (*conn, error) {
+	mountMu.Lock()
+	defer mountMu.Unlock()
+	if mounted != nil {
+		return mounted, nil
+	}
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	mounted = c
+	return c, nil
+}
+
+// unmount closes the shared connection so that the next call to mount
+// dials acme again. It is used once acme has gone away.
+func unmount() {
+	mountMu.Lock()
+	defer mountMu.Unlock()
+	if mounted != nil {
+		mounted.Close()
+		mounted = nil
+	}
+}
+
+// windows returns the names of the open acme windows by ID
+func windows() (map[int]string, error) {
+	infos, err := index()
+	if err != nil {
+		return nil, err
+	}
+	ws := make(map[int]string)
+	for id, info := range infos {
+		ws[id] = info.Name
+	}
+	return ws, nil
+}
+
+// index reads the name, tag and directory flag of every window from
+// the acme index
+func index() (map[int]WinInfo, error) {
+	c, err := mount()
+	if err != nil {
+		return nil, err
+	}
+	index, err := c.open("index", plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	data, err := ioutil.ReadAll(index)
+	if err != nil {
+		return nil, err
+	}
+	ws := make(map[int]WinInfo)
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 6 {
+			continue
+		}
+		id, err := strconv.Atoi(f[0])
+		if err != nil {
+			continue
+		}
+		// the tag follows five numbers of 11 characters and a space
+		var tag Tag
+		if len(line) > 5*12 {
+			tag = ParseTag([]byte(line[5*12:]))
+		}
+		if tag.File == "" {
+			tag.File = f[5]
+		}
+		ws[id] = WinInfo{ID: id, Name: tag.File, IsDir: f[3] == "1", Tag: tag}
+	}
+	return ws, nil
+}
+
+// open opens the named file in acme
+func (c *conn) open(name string, mode uint8) (*client.Fid, error) {
+	return c.fsys.Open(name, mode)
+}
+
+// Close closes the connection, closing every file opened on it
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// logReader reads operations from the acme log
+type logReader struct {
+	f   *client.Fid
+	buf [8192]byte
+}
+
+// openLog opens the acme log on the conn
+func openLog(c *conn) (*logReader, error) {
+	f, err := c.open("log", plan9.OREAD)
+	if err != nil {
+		return nil, err
+	}
+	return &logReader{f: f}, nil
+}
+
+// Read reads the next operation from the log. Acme returns a single
+// operation per read.
+func (r *logReader) Read() (LogEvent, error) {
+	n, err := r.f.Read(r.buf[:])
+	if err != nil {
+		return LogEvent{}, err
+	}
+	f := strings.SplitN(string(r.buf[:n]), " ", 3)
+	if len(f) != 3 {
+		return LogEvent{}, fmt.Errorf("malformed log event %q", r.buf[:n])
+	}
+	id, err := strconv.Atoi(f[0])
+	if err != nil {
+		return LogEvent{}, fmt.Errorf("malformed log event %q", r.buf[:n])
+	}
+	return LogEvent{
+		ID:   id,
+		Op:   NewLogText(f[1]),
+		Name: strings.TrimSpace(f[2]),
+	}, nil
+}
+
+// eventReader reads events from a window's event file
+type eventReader struct {
+	c  *conn
+	id int
+	f  *client.Fid
+	r  *bufio.Reader
+}
+
+// openEvents opens the event file of the window on the conn
+func openEvents(c *conn, id int) (*eventReader, error) {
+	f, err := c.open(fmt.Sprintf("%d/event", id), plan9.ORDWR)
+	if err != nil {
+		return nil, err
+	}
+	return &eventReader{c: c, id: id, f: f, r: bufio.NewReader(f)}, nil
+}
+
+// Read reads the next event, merging the messages acme sends for
+// expansions and chorded arguments into a single event. Text that acme
+// omitted from an execute or look event because it was too long is
+// read from the window.
+func (r *eventReader) Read() (*acme.Event, error) {
+	e, err := r.merge()
+	if err != nil {
+		return nil, err
+	}
+	if e.Nr == 0 && e.Q1 > e.Q0 {
+		if err := r.text(e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// merge reads the primary message of an event and the messages that
+// follow it
+func (r *eventReader) merge() (*acme.Event, error) {
+	e, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	e.OrigQ0 = e.Q0
+	e.OrigQ1 = e.Q1
+
+	// expansion
+	if e.Flag&2 != 0 {
+		e2, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if e.Q0 == e.Q1 {
+			e2.OrigQ0 = e.Q0
+			e2.OrigQ1 = e.Q1
+			e2.Flag = e.Flag
+			e = e2
+		}
+	}
+
+	// chorded argument
+	if e.Flag&8 != 0 {
+		arg, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		loc, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		e.Arg = arg.Text
+		e.Loc = loc.Text
+	}
+	return e, nil
+}
+
+// text fills in the text of an execute or look event from the body
+// or tag
+func (r *eventReader) text(e *acme.Event) error {
+	var file string
+	switch e.C2 {
+	case 'X', 'L':
+		file = "body"
+	case 'x', 'l':
+		file = "tag"
+	default:
+		return nil
+	}
+	f, err := r.c.open(fmt.Sprintf("%d/%s", r.id, file), plan9.OREAD)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	text := []rune(string(b))
+	if e.Q1 > len(text) {
+		return fmt.Errorf("event address %d,%d out of range", e.Q0, e.Q1)
+	}
+	e.Text = []byte(string(text[e.Q0:e.Q1]))
+	e.Nr = e.Q1 - e.Q0
+	return nil
+}
+
+// Write writes the event back to acme
+func (r *eventReader) Write(e *acme.Event) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%c%c%d %d \n", e.C1, e.C2, e.Q0, e.Q1)
+	_, err := r.f.Write(buf.Bytes())
+	return err
+}
+
+// read reads a single event message
+func (r *eventReader) read() (*acme.Event, error) {
+	e := new(acme.Event)
+	var err error
+	if e.C1, err = r.rune(); err != nil {
+		return nil, err
+	}
+	if e.C2, err = r.rune(); err != nil {
+		return nil, err
+	}
+	for _, n := range []*int{&e.Q0, &e.Q1, &e.Flag, &e.Nr} {
+		if *n, err = r.number(); err != nil {
+			return nil, err
+		}
+	}
+	if e.Nr > eventSize {
+		return nil, errors.New("event string too long")
+	}
+	text := make([]rune, e.Nr)
+	for i := range text {
+		if text[i], err = r.rune(); err != nil {
+			return nil, err
+		}
+	}
+	e.Text = []byte(string(text))
+	if c, err := r.rune(); err != nil {
+		return nil, err
+	} else if c != '\n' {
+		return nil, errors.New("event phase error")
+	}
+	return e, nil
+}
+
+func (r *eventReader) rune() (rune, error) {
+	c, _, err := r.r.ReadRune()
+	return c, err
+}
+
+func (r *eventReader) number() (int, error) {
+	n := 0
+	for {
+		c, err := r.rune()
+		if err != nil {
+			return 0, err
+		}
+		if c == ' ' {
+			return n, nil
+		}
+		if c < '0' || c > '9' {
+			return 0, errors.New("event number syntax")
+		}
+		n = n*10 + int(c-'0')
+	}
+}
@@ -0,0 +1,264 @@
+package nyne
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// Hunk replaces the runes between Q0 and Q1 of a text with Text
+type Hunk struct {
+	Q0, Q1 int
+	Text   []byte
+}
+
+// maxEdits bounds the number of changed lines Diff searches for.
+// Texts that differ by more are replaced in a single hunk.
+const maxEdits = 1000
+
+type edit int
+
+const (
+	keep edit = iota
+	del
+	ins
+)
+
+// Diff returns the hunks that turn a into b, ordered by their
+// position in a. The changed lines are found first and then trimmed
+// to the runes that differ, so applying the hunks from last to first
+// leaves the unchanged text, and acme's selection in it, untouched.
+func Diff(a, b []byte) []Hunk {
+	al, bl := lines(a), lines(b)
+
+	// skip the lines the texts start and end with
+	pre := 0
+	for pre < len(al) && pre < len(bl) && bytes.Equal(al[pre], bl[pre]) {
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre &&
+		bytes.Equal(al[len(al)-1-suf], bl[len(bl)-1-suf]) {
+		suf++
+	}
+	am, bm := al[pre:len(al)-suf], bl[pre:len(bl)-suf]
+	if len(am) == 0 && len(bm) == 0 {
+		return nil
+	}
+	q := 0
+	for _, l := range al[:pre] {
+		q += utf8.RuneCount(l)
+	}
+
+	edits, ok := myers(am, bm)
+	if !ok {
+		edits = nil
+		for range am {
+			edits = append(edits, del)
+		}
+		for range bm {
+			edits = append(edits, ins)
+		}
+	}
+
+	var hunks []Hunk
+	var old []byte
+	h := Hunk{Q0: q, Q1: q}
+	i, j := 0, 0
+	flush := func() {
+		if h.Q0 != h.Q1 || len(h.Text) > 0 {
+			hunks = append(hunks, trim(h, old))
+		}
+		old = nil
+		h = Hunk{Q0: q, Q1: q}
+	}
+	for _, e := range edits {
+		switch e {
+		case keep:
+			flush()
+			q += utf8.RuneCount(am[i])
+			h = Hunk{Q0: q, Q1: q}
+			i++
+			j++
+		case del:
+			old = append(old, am[i]...)
+			q += utf8.RuneCount(am[i])
+			h.Q1 = q
+			i++
+		case ins:
+			h.Text = append(h.Text, bm[j]...)
+			j++
+		}
+	}
+	flush()
+	return hunks
+}
+
+// applyHunks applies the hunks to the body of the window from last to
+// first so that the offsets of the earlier hunks stay valid
+func applyHunks(w Window, hunks []Hunk) error {
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		if err := w.SetAddr("#%d,#%d", h.Q0, h.Q1); err != nil {
+			return err
+		}
+		if err := w.SetData(h.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shift returns where the rune offset q moves to once the hunks are
+// applied. Offsets inside replaced text are kept inside its
+// replacement.
+func Shift(q int, hunks []Hunk) int {
+	delta := 0
+	for _, h := range hunks {
+		if h.Q0 >= q {
+			break
+		}
+		n := utf8.RuneCount(h.Text)
+		if h.Q1 <= q {
+			delta += n - (h.Q1 - h.Q0)
+			continue
+		}
+		if q-h.Q0 > n {
+			return h.Q0 + n + delta
+		}
+		break
+	}
+	return q + delta
+}
+
+// Rebase moves hunks made against base so that they apply to cur,
+// which is base with edits made since. It returns false if one of the
+// edits touches the text a hunk replaces, as the hunk would then undo
+// the edit.
+func Rebase(base, cur []byte, hunks []Hunk) ([]Hunk, bool) {
+	edits := Diff(base, cur)
+	rebased := make([]Hunk, 0, len(hunks))
+	for _, h := range hunks {
+		delta := 0
+		for _, e := range edits {
+			if e.Q1 < h.Q0 {
+				delta += utf8.RuneCount(e.Text) - (e.Q1 - e.Q0)
+				continue
+			}
+			if e.Q0 <= h.Q1 {
+				return nil, false
+			}
+			break
+		}
+		h.Q0 += delta
+		h.Q1 += delta
+		rebased = append(rebased, h)
+	}
+	return rebased, true
+}
+
+// lines splits text after each newline
+func lines(text []byte) [][]byte {
+	l := bytes.SplitAfter(text, []byte("\n"))
+	if len(l[len(l)-1]) == 0 {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+// trim narrows the hunk to the runes that differ from the old text
+func trim(h Hunk, old []byte) Hunk {
+	for len(old) > 0 && len(h.Text) > 0 {
+		r1, n1 := utf8.DecodeRune(old)
+		r2, n2 := utf8.DecodeRune(h.Text)
+		if r1 != r2 || n1 != n2 {
+			break
+		}
+		old, h.Text = old[n1:], h.Text[n2:]
+		h.Q0++
+	}
+	for len(old) > 0 && len(h.Text) > 0 {
+		r1, n1 := utf8.DecodeLastRune(old)
+		r2, n2 := utf8.DecodeLastRune(h.Text)
+		if r1 != r2 || n1 != n2 {
+			break
+		}
+		old, h.Text = old[:len(old)-n1], h.Text[:len(h.Text)-n2]
+		h.Q1--
+	}
+	return h
+}
+
+// myers returns the shortest edit script that turns the lines of a
+// into the lines of b using Myers' algorithm. It gives up once more
+// than maxEdits lines have changed.
+func myers(a, b [][]byte) ([]edit, bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxEdits {
+		max = maxEdits
+	}
+	// v holds the furthest x reached on each diagonal k = x - y
+	off := max + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+				return backtrack(trace, n, m), true
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+	return nil, false
+}
+
+// backtrack follows the path found by myers back from the end of both
+// texts, where trace[d][k+d] is the furthest x on diagonal k after d
+// edits
+func backtrack(trace [][]int, x, y int) []edit {
+	var edits []edit
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		pk := k - 1
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			pk = k + 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+		for x > px && y > py {
+			edits = append(edits, keep)
+			x--
+			y--
+		}
+		if x == px {
+			edits = append(edits, ins)
+			y--
+		} else {
+			edits = append(edits, del)
+			x--
+		}
+		x, y = px, py
+	}
+	for x > 0 {
+		edits = append(edits, keep)
+		x--
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
@@ -0,0 +1,115 @@
+package nyne
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// patch applies the hunks to the text from last to first like acme
+func patch(text string, hunks []Hunk) string {
+	r := []rune(text)
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		r = append(r[:h.Q0], append([]rune(string(h.Text)), r[h.Q1:]...)...)
+	}
+	return string(r)
+}
+
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected []Hunk
+	}{
+		{"", "", nil},
+		{"a\nb\n", "a\nb\n", nil},
+		{"", "a\n", []Hunk{{0, 0, []byte("a\n")}}},
+		{"a\n", "", []Hunk{{0, 2, nil}}},
+		{
+			"package main\nfunc  main(){\n}\n",
+			"package main\nfunc main() {\n}\n",
+			[]Hunk{{18, 25, []byte("main() ")}},
+		},
+		{
+			"ä\nb\nc\nd\n",
+			"ä\nB\nc\nd\ne\n",
+			[]Hunk{{2, 3, []byte("B")}, {8, 8, []byte("e\n")}},
+		},
+		{"a\nb", "a\nb\n", []Hunk{{3, 3, []byte("\n")}}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.b), func(t *testing.T) {
+			hunks := Diff([]byte(tc.a), []byte(tc.b))
+			if !reflect.DeepEqual(hunks, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, hunks)
+			}
+			if got := patch(tc.a, hunks); got != tc.b {
+				t.Fatalf("expected %q, got %q", tc.b, got)
+			}
+		})
+	}
+}
+
+func TestDiffRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"a\n", "b\n", "é\n", "c", "\n", "dd\n"}
+	text := func() string {
+		var b strings.Builder
+		for i := r.Intn(30); i > 0; i-- {
+			b.WriteString(words[r.Intn(len(words))])
+		}
+		return b.String()
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := text(), text()
+		if got := patch(a, Diff([]byte(a), []byte(b))); got != b {
+			t.Fatalf("diff of %q and %q gave %q", a, b, got)
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	hunks := []Hunk{{2, 4, []byte("x")}, {6, 6, []byte("yyy")}}
+	testCases := []struct {
+		q, expected int
+	}{
+		{0, 0},
+		{2, 2},
+		{3, 3},
+		{4, 3},
+		{6, 5},
+		{7, 9},
+	}
+	for _, tc := range testCases {
+		if got := Shift(tc.q, hunks); got != tc.expected {
+			t.Fatalf("expected %d to shift to %d, got %d", tc.q, tc.expected, got)
+		}
+	}
+}
+
+func TestRebase(t *testing.T) {
+	testCases := []struct {
+		base, cur, fmt string
+		expected       string
+		ok             bool
+	}{
+		{"before\nx", "before\nx", "after\nx", "after\nx", true},
+		{"before\nx", "before\nxTYPED", "after\nx", "after\nxTYPED", true},
+		{"a\nbefore\n", "typed\na\nbefore\n", "a\nafter\n", "typed\na\nafter\n", true},
+		{"before\nx", "beTYPEDfore\nx", "after\nx", "", false},
+	}
+	for _, tc := range testCases {
+		hunks, ok := Rebase([]byte(tc.base), []byte(tc.cur), Diff([]byte(tc.base), []byte(tc.fmt)))
+		if ok != tc.ok {
+			t.Fatalf("%q: expected ok to be %v", tc.cur, tc.ok)
+		}
+		if !ok {
+			continue
+		}
+		if got := patch(tc.cur, hunks); got != tc.expected {
+			t.Fatalf("expected %q, got %q", tc.expected, got)
+		}
+	}
+}
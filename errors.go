@@ -0,0 +1,69 @@
+package nyne
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// errorsMu keeps concurrent reports from creating the same window twice
+var errorsMu sync.Mutex
+
+// HookError is an error from running the hooks of a window
+type HookError struct {
+	ID   int
+	File string
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.File, e.Hook, e.Err)
+}
+
+// Unwrap returns the error of the hook
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// StderrErrors writes the error to standard error
+func StderrErrors(err *HookError) {
+	fmt.Fprintln(os.Stderr, err)
+}
+
+// AcmeErrors writes the error to the +Errors window of the file's
+// directory. Errors of windows that are gone are written to the +nyne
+// window instead.
+func AcmeErrors(err *HookError) {
+	if err.ID > 0 {
+		if w, oerr := OpenWin(err.ID, err.File); oerr == nil {
+			werr := w.Errorf("%v\n", err)
+			w.Close()
+			if werr == nil {
+				return
+			}
+		}
+	}
+	WindowErrors("+nyne")(err)
+}
+
+// WindowErrors returns an ErrorHandler that appends errors to the
+// window named name, falling back to standard error if the window
+// cannot be written
+func WindowErrors(name string) ErrorHandler {
+	return func(err *HookError) {
+		errorsMu.Lock()
+		defer errorsMu.Unlock()
+		o, oerr := OutputWin(name, OutputOptions{Mode: OutputAppend})
+		if oerr != nil {
+			StderrErrors(err)
+			return
+		}
+		defer o.Close()
+		if _, werr := fmt.Fprintf(o, "%v\n", err); werr != nil {
+			StderrErrors(err)
+			return
+		}
+		o.Clean()
+	}
+}
@@ -0,0 +1,27 @@
+package nyne
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWindowErrors(t *testing.T) {
+	defer fsrv.Reset()
+	report := WindowErrors("+nyne")
+	report(&HookError{File: "/tmp/a.go", Hook: "Put", Err: errors.New("one")})
+	report(&HookError{File: "/tmp/b.go", Hook: "New", Err: errors.New("two")})
+	fw := fsrv.Lookup("+nyne")
+	if fw == nil {
+		t.Fatal("expected a +nyne window")
+	}
+	expected := "/tmp/a.go: Put: one\n/tmp/b.go: New: two\n"
+	if fw.Body() != expected {
+		t.Fatalf("expected %q, got %q", expected, fw.Body())
+	}
+	if fw.Dirty() {
+		t.Fatal("expected the window to be clean")
+	}
+	if n := len(fsrv.Windows()); n != 1 {
+		t.Fatalf("expected 1 window, got %d", n)
+	}
+}
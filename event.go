@@ -1,6 +1,10 @@
 package nyne
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
 	"9fans.net/go/acme"
 )
 
@@ -8,7 +12,9 @@ import (
 //
 // The message includes the text if it is less than 256 chars. If it
 // is longer than that, the fourth number is 0 and the body must be read
-// through the data file
+// through the data file. Win.EventChan does this for execute and look
+// events, and merges the extra messages acme sends for expansions and
+// chorded arguments into a single Event.
 type Event struct {
 	// Log
 	ID                       int
@@ -23,8 +29,24 @@ type Event struct {
 	NumRunes                 int
 	ChordArg                 []byte
 	ChordLoc                 []byte
+	ChordOrigin              Location
 	// Hooks
-	WriteHooks []Hook
+	WriteHooks []Hook `json:"-"`
+	// writeNames names the hooks that added the write hooks
+	writeNames []string
+	// ctx is the context of the write hook the event is given to
+	ctx context.Context
+}
+
+// Context returns the context of the write hook the event was given
+// to. It is done when the hook times out or its Buf stops, and hooks
+// that do long running work should stop when it is. Events that were
+// not given to a write hook return context.Background().
+func (e Event) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
 }
 
 // Text contains the default acme event types
@@ -45,6 +67,31 @@ const (
 	Focus Text = "Focus"
 )
 
+// logOps maps the operations written to the acme log to their Text
+var logOps = map[string]Text{
+	"new":   New,
+	"zerox": Zerox,
+	"get":   Get,
+	"put":   Put,
+	"del":   Del,
+	"focus": Focus,
+}
+
+// NewLogText constructs a builtin from an acme log operation
+func NewLogText(op string) Text {
+	if t, ok := logOps[op]; ok {
+		return t
+	}
+	return Text(op)
+}
+
+// LogEvent is a window operation read from the acme log
+type LogEvent struct {
+	ID   int
+	Name string
+	Op   Text
+}
+
 // NewText constructs a builtin from the event text
 func NewText(text []byte) Text {
 	return Text(text)
@@ -89,6 +136,18 @@ func (o Origin) Rune() rune {
 	return rune(o)
 }
 
+// MarshalText encodes the Origin as the character acme uses for it
+func (o Origin) MarshalText() ([]byte, error) {
+	return runeText(rune(o)), nil
+}
+
+// UnmarshalText decodes the Origin from the character acme uses for it
+func (o *Origin) UnmarshalText(text []byte) error {
+	r, err := textRune(text)
+	*o = Origin(r)
+	return err
+}
+
 // Action describes what kind of action was taken
 type Action rune
 
@@ -126,77 +185,172 @@ func (a Action) Rune() rune {
 	return rune(a)
 }
 
+// MarshalText encodes the Action as the character acme uses for it
+func (a Action) MarshalText() ([]byte, error) {
+	return runeText(rune(a)), nil
+}
+
+// UnmarshalText decodes the Action from the character acme uses for it
+func (a *Action) UnmarshalText(text []byte) error {
+	r, err := textRune(text)
+	*a = Action(r)
+	return err
+}
+
+// runeText encodes r as text, leaving the text of zero empty
+func runeText(r rune) []byte {
+	if r == 0 {
+		return nil
+	}
+	return []byte(string(r))
+}
+
+func textRune(text []byte) (rune, error) {
+	r := []rune(string(text))
+	switch len(r) {
+	case 0:
+		return 0, nil
+	case 1:
+		return r[0], nil
+	}
+	return 0, fmt.Errorf("%q is not a single character", text)
+}
+
 // Flag contains the flag for the event. For BodyDelete, TagDelete,
 // BodyInsert, and TagInsert the flag is always zero. For messages with
 // the 1 bit on in the flag, writing the message back to the event file,
 // but with the flag, count, and text omitted, will cause the action to be
 // applied to the file exactly as it would have been if the event file had
 // not been open.
+//
+// Flag holds the bits exactly as acme sent them. The meaning of each
+// bit depends on the Action, so the predicates on Event, such as
+// Event.IsBuiltin, should be used to test them.
 type Flag int
 
 const (
 	// when action is B2Body and B2Tag
 
 	// IsBuiltin represents a built-in command
-	IsBuiltin Flag = iota
+	IsBuiltin Flag = 1
 
 	// IsNull represents if the text is a null string that has a
 	// non-null expansion; if so, another complete message will
 	// follow describing the expansion exactly as if it had been
 	// indicated explicitly (its flag will always be 0)
-	IsNull
+	IsNull Flag = 2
 
 	// HasChordedArg says if the command has an extra (chorded)
 	// argument; if so, two more complete messages will follow
 	// reporting the argument (with all numbers 0 except the
 	// character count) and where it originated, in the form of a
 	// fully-qualified button 3 style address.
-	HasChordedArg
+	HasChordedArg Flag = 8
 
 	// when action is B3Body or B3Tag
 
 	// NoReloadNeeded says if acme can interpret the action without
 	// loading a new file
-	NoReloadNeeded
+	NoReloadNeeded Flag = 1
 
 	// PostExpandFollows says if a second (post-expansion) message
 	// follows, analogous to that with X messages
-	PostExpandFollows
+	PostExpandFollows Flag = 2
 
 	// IsFileOrWindow says If the text is a file or window name
 	// (perhaps with address) rather than plain literal text.
-	IsFileOrWindow
-
-	// For messages with the 1 bit on in the flag, writing the message
-	// back to the event file, but with the flag, count, and text omitted,
-	// will cause the action to be applied to the file exactly as it would
-	// have been if the event file had not been open.
+	IsFileOrWindow Flag = 4
 )
 
-// NewFlag constructs a Flag
+// NewFlag constructs a Flag from the raw acme flag. The action is no
+// longer needed, as the Flag keeps every bit acme sent.
+//
+// Deprecated: convert the raw flag with Flag(rawFlag) and test it with
+// the predicates on Event. The Flag constants are now the bits acme
+// uses, so a Flag no longer equals a single constant when acme sets
+// more than one bit.
 func NewFlag(a Action, rawFlag int) Flag {
-	if a == B2Body || a == B2Tag {
-		switch rawFlag {
-		case 1:
-			return IsBuiltin
-		case 2:
-			return IsNull
-		case 3, 8:
-			return HasChordedArg
-		}
+	return Flag(rawFlag)
+}
+
+// Has reports whether every bit in f2 is set in f
+func (f Flag) Has(f2 Flag) bool {
+	return f&f2 == f2
+}
+
+// IsExec reports whether the event executes text with button 2
+func (e Event) IsExec() bool {
+	return e.Action == B2Body || e.Action == B2Tag
+}
+
+// IsLook reports whether the event looks up text with button 3
+func (e Event) IsLook() bool {
+	return e.Action == B3Body || e.Action == B3Tag
+}
+
+// IsBuiltin reports whether an executed command is built in to acme
+func (e Event) IsBuiltin() bool {
+	return e.IsExec() && e.Flag.Has(IsBuiltin)
+}
+
+// IsNull reports whether an executed command was a null string that
+// has been replaced by its expansion
+func (e Event) IsNull() bool {
+	return e.IsExec() && e.Flag.Has(IsNull)
+}
+
+// HasChordedArg reports whether an executed command has a chorded
+// argument
+func (e Event) HasChordedArg() bool {
+	return e.IsExec() && e.Flag.Has(HasChordedArg)
+}
+
+// NoReloadNeeded reports whether acme can look up the text without
+// loading a new file
+func (e Event) NoReloadNeeded() bool {
+	return e.IsLook() && e.Flag.Has(NoReloadNeeded)
+}
+
+// PostExpandFollows reports whether the looked up text was expanded
+func (e Event) PostExpandFollows() bool {
+	return e.IsLook() && e.Flag.Has(PostExpandFollows)
+}
+
+// IsFileOrWindow reports whether the looked up text is a file or
+// window name rather than literal text
+func (e Event) IsFileOrWindow() bool {
+	return e.IsLook() && e.Flag.Has(IsFileOrWindow)
+}
+
+// Location is the fully qualified address acme reports for the origin
+// of a chorded argument, such as /home/user/file.go:#10,#15
+type Location struct {
+	File   string
+	Q0, Q1 int
+}
+
+// ParseLocation parses a location of the form file:#q0,#q1. The file
+// is omitted when the argument did not come from a file.
+func ParseLocation(loc []byte) (Location, error) {
+	var l Location
+	s := string(loc)
+	i := strings.LastIndex(s, ":#")
+	if i >= 0 {
+		l.File = s[:i]
+		s = s[i+1:]
+	}
+	if _, err := fmt.Sscanf(s, "#%d,#%d", &l.Q0, &l.Q1); err != nil {
+		return Location{}, fmt.Errorf("malformed location %q", loc)
 	}
+	return l, nil
+}
 
-	if a == B3Body || a == B3Tag {
-		switch rawFlag {
-		case 1:
-			return NoReloadNeeded
-		case 2:
-			return PostExpandFollows
-		case 4:
-			return IsFileOrWindow
-		}
+// String returns the Location in the form acme reports it
+func (l Location) String() string {
+	if l.File == "" {
+		return fmt.Sprintf("#%d,#%d", l.Q0, l.Q1)
 	}
-	return Flag(rawFlag)
+	return fmt.Sprintf("%s:#%d,#%d", l.File, l.Q0, l.Q1)
 }
 
 // NewEvent constructs an Event from a raw acme event
@@ -216,7 +370,12 @@ func NewEvent(event *acme.Event, id int, file string) (Event, error) {
 		ChordArg:     event.Arg,
 		ChordLoc:     event.Loc,
 	}
-	e.Flag = NewFlag(e.Action, event.Flag)
+	e.Flag = Flag(event.Flag)
+	if len(event.Loc) > 0 {
+		// an unexpected location leaves ChordOrigin unset rather
+		// than dropping the event
+		e.ChordOrigin, _ = ParseLocation(event.Loc)
+	}
 	return e, nil
 }
 
@@ -2,6 +2,7 @@ package nyne
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"9fans.net/go/acme"
@@ -30,6 +31,30 @@ func TestBuiltin(t *testing.T) {
 	}
 }
 
+func TestLogText(t *testing.T) {
+	testCases := []struct {
+		given    string
+		expected Text
+	}{
+		{"new", New},
+		{"zerox", Zerox},
+		{"get", Get},
+		{"put", Put},
+		{"del", Del},
+		{"focus", Focus},
+		{"bla", "bla"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.given, func(t *testing.T) {
+			text := NewLogText(tc.given)
+			if text != tc.expected {
+				t.Fatalf("expected text %s, got %s",
+					tc.expected, text)
+			}
+		})
+	}
+}
+
 func TestOrigin(t *testing.T) {
 	testCases := []struct {
 		given    rune
@@ -79,24 +104,70 @@ func TestAction(t *testing.T) {
 }
 
 func TestFlag(t *testing.T) {
+	type predicate struct {
+		name string
+		fn   func(Event) bool
+	}
+	predicates := []predicate{
+		{"IsBuiltin", Event.IsBuiltin},
+		{"IsNull", Event.IsNull},
+		{"HasChordedArg", Event.HasChordedArg},
+		{"NoReloadNeeded", Event.NoReloadNeeded},
+		{"PostExpandFollows", Event.PostExpandFollows},
+		{"IsFileOrWindow", Event.IsFileOrWindow},
+	}
 	testCases := []struct {
 		given    int
 		action   Action
-		expected Flag
+		expected []string
 	}{
-		{1, B2Body | B2Tag, IsBuiltin},
-		{2, B2Body | B2Tag, IsNull},
-		{8, B2Body | B2Tag, HasChordedArg},
-		{1, B3Body | B3Tag, NoReloadNeeded},
-		{2, B3Body | B3Tag, PostExpandFollows},
-		{4, B3Body | B3Tag, IsFileOrWindow},
+		{1, B2Body, []string{"IsBuiltin"}},
+		{2, B2Tag, []string{"IsNull"}},
+		{8, B2Tag, []string{"HasChordedArg"}},
+		{11, B2Body, []string{"IsBuiltin", "IsNull", "HasChordedArg"}},
+		{1, B3Body, []string{"NoReloadNeeded"}},
+		{2, B3Tag, []string{"PostExpandFollows"}},
+		{4, B3Body, []string{"IsFileOrWindow"}},
+		{6, B3Body, []string{"PostExpandFollows", "IsFileOrWindow"}},
+		{0, BodyInsert, nil},
 	}
 	for _, tc := range testCases {
-		t.Run(fmt.Sprintf("action=%d flag=%d", tc.action, tc.given), func(t *testing.T) {
-			flag := NewFlag(tc.action, tc.given)
-			if flag != tc.expected {
-				t.Fatalf("expected flag %d, got %d",
-					tc.expected, flag)
+		t.Run(fmt.Sprintf("action=%c flag=%d", tc.action, tc.given), func(t *testing.T) {
+			e := Event{Action: tc.action, Flag: Flag(tc.given)}
+			if NewFlag(tc.action, tc.given) != e.Flag {
+				t.Fatalf("expected flag %d, got %d", tc.given, e.Flag)
+			}
+			set := make(map[string]bool)
+			for _, name := range tc.expected {
+				set[name] = true
+			}
+			for _, p := range predicates {
+				if p.fn(e) != set[p.name] {
+					t.Fatalf("expected %s to be %v", p.name, set[p.name])
+				}
+			}
+		})
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	testCases := []*acme.Event{
+		{C1: 'M', C2: 'L', Q0: 4, Q1: 9, OrigQ0: 4, OrigQ1: 4, Flag: 6, Nr: 5, Text: []byte("a.txt")},
+		{C1: 'M', C2: 'x', Q0: 0, Q1: 4, OrigQ0: 0, OrigQ1: 4, Flag: 9, Nr: 4, Text: []byte("Look"), Arg: []byte("x"), Loc: []byte("/tmp/x:#0,#1")},
+		{C1: 'K', C2: 'I', Q0: 3, Q1: 4, OrigQ0: 3, OrigQ1: 4, Nr: 1, Text: []byte("ä")},
+	}
+	for _, raw := range testCases {
+		t.Run(string(raw.Text), func(t *testing.T) {
+			e, err := NewEvent(raw, 1, "/tmp/x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			log, err := e.Log()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(log, raw) {
+				t.Fatalf("expected %+v, got %+v", raw, log)
 			}
 		})
 	}
@@ -131,3 +202,31 @@ func TestNewEvent(t *testing.T) {
 		t.Fatal(event.Flag)
 	}
 }
+
+func TestParseLocation(t *testing.T) {
+	testCases := []struct {
+		given    string
+		expected Location
+	}{
+		{"/tmp/file.go:#10,#15", Location{"/tmp/file.go", 10, 15}},
+		{"/tmp/a:b.txt:#0,#3", Location{"/tmp/a:b.txt", 0, 3}},
+		{"#4,#4", Location{"", 4, 4}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.given, func(t *testing.T) {
+			loc, err := ParseLocation([]byte(tc.given))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loc != tc.expected {
+				t.Fatalf("expected location %v, got %v", tc.expected, loc)
+			}
+			if loc.String() != tc.given {
+				t.Fatalf("expected %s, got %s", tc.given, loc)
+			}
+		})
+	}
+	if _, err := ParseLocation([]byte("/tmp/file.go:12")); err == nil {
+		t.Fatal("expected an error for a line address")
+	}
+}
@@ -1,16 +1,34 @@
 package nyne
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"strconv"
 )
 
+// ErrEdited is reported when the window was edited where it was being
+// formatted. The formatting is dropped and the window is not written.
+var ErrEdited = errors.New("window was edited where it was being formatted")
+
 // Formatter formats acme windows and buffers
 type Formatter struct {
+	// Open opens the windows being formatted
+	Open WinOpener
+	// Reconnect is passed on to the Acme the Formatter listens with
+	Reconnect *Backoff
+	// Shadow keeps the bodies being formatted in memory
+	Shadow bool
+	// Errors receives the errors of formatting and of the hooks
+	// the Formatter runs
+	Errors ErrorHandler
+	// Record is the directory each window is recorded to, for
+	// replaying with Replay. Windows are not recorded if it is empty.
+	Record string
 	acme   *Acme
 	debug  bool
 	config map[string]Filetype
@@ -19,6 +37,8 @@ type Formatter struct {
 // NewFormatter constructs a Formatter
 func NewFormatter(filetypes []Filetype, menutag []string) (*Formatter, error) {
 	f := &Formatter{
+		Open:   OpenWindow,
+		Errors: StderrErrors,
 		acme:   NewAcme(),
 		debug:  len(os.Getenv("DEBUG")) > 0,
 		config: make(map[string]Filetype),
@@ -28,48 +48,46 @@ func NewFormatter(filetypes []Filetype, menutag []string) (*Formatter, error) {
 		return nil, err
 	}
 
-	f.acme.WinHooks = map[Text][]WinHandler{
-		New: {
-			func(w *Win) {
-				ft, _ := f.filetype(w.File)
-				if ft.Tabwidth != 0 {
-					f.fmt(w, ft)
-				}
-				for _, opt := range menutag {
-					err := w.AppendTag(opt)
-					if err != nil {
-						panic(err)
-					}
-				}
-			},
-		},
+	exts := make([]string, 0, len(f.config))
+	for ext := range f.config {
+		exts = append(exts, ext)
 	}
+	r := f.acme.Router
 
-	f.acme.EventHooks = map[Text][]Handler{
-		Put: {
-			func(evt Event) (Event, bool) {
-				evt.WriteHooks = append(evt.WriteHooks, func(e Event) error {
-					ft, ext := f.filetype(evt.File)
-					if ft.Tabwidth == 0 {
-						return nil
-					}
-					err := f.exec(evt, ft.Commands, ext)
-					if err != nil {
-						log.Println(err)
-					}
-					return nil
-				})
-				return evt, true
-			},
-		},
-	}
+	r.HandleWin(Route{Name: "nyne/tab", Text: New, Extensions: exts}, func(w Window) {
+		ft, _ := f.filetype(w.File())
+		if err := f.fmt(w, ft); err != nil {
+			f.report(w.ID(), w.File(), "New", err)
+		}
+	})
+	r.HandleWin(Route{Name: "nyne/menu", Text: New}, func(w Window) {
+		if err := SetMenu(w, menutag); err != nil {
+			f.report(w.ID(), w.File(), "New", err)
+		}
+	})
+
+	r.Handle(Route{Name: "nyne/format", Text: Put, Extensions: exts}, func(evt Event) (Event, bool) {
+		evt.WriteHooks = append(evt.WriteHooks, func(e Event) error {
+			ft, ext := f.filetype(e.File)
+			if ft.Tabwidth == 0 {
+				return nil
+			}
+			err := f.exec(e, ft.Commands, ext)
+			if err != nil && e.Context().Err() == nil {
+				// a hook that timed out has already been reported
+				f.report(e.ID, e.File, "Put", err)
+			}
+			return nil
+		})
+		return evt, true
+	})
 
 	key, expand := Tabexpand(
 		func(evt Event) bool {
 			ft, _ := f.filetype(evt.File)
 			return ft.Tabexpand
 		},
-		func(id int) (*Win, error) {
+		func(id int) (Window, error) {
 			l := f.acme.Buf(id)
 			if l == nil {
 				return nil, fmt.Errorf("could not find event loop")
@@ -82,45 +100,101 @@ func NewFormatter(filetypes []Filetype, menutag []string) (*Formatter, error) {
 				return 8 // default
 			}
 			return ft.Tabwidth
+		},
+		func(err *HookError) {
+			f.report(err.ID, err.File, err.Hook, err.Err)
 		})
-	f.acme.KeyHooks = map[rune]Handler{
-		key: expand,
-	}
+	r.Handle(Route{Name: "nyne/tabexpand", Key: key, Extensions: exts}, expand)
 
 	return f, nil
 }
 
+// Router returns the Router the Formatter's hooks are registered on so
+// that other hooks can be run alongside them
+func (f *Formatter) Router() *Router {
+	return f.acme.Router
+}
+
 // Run tells the Formatter to begin listening for Acme events
 func (f *Formatter) Run() error {
-	return f.acme.Listen()
+	return f.RunContext(context.Background())
+}
+
+// RunContext is like Run but stops formatting when ctx is done,
+// killing any formatting commands that are still running
+//
+// When $DEBUG is set, each event read and the result of each hook run
+// on it are traced to the +nyne/trace window.
+func (f *Formatter) RunContext(ctx context.Context) error {
+	f.configure()
+	if f.debug {
+		tw := &traceWin{}
+		defer tw.Close()
+		f.acme.Trace = TraceTo(tw)
+		f.acme.ConnectHooks = []func() error{tw.open}
+	}
+	return f.acme.ListenContext(ctx)
+}
+
+// RunWindow formats the window alone until it is deleted or ctx is
+// done
+func (f *Formatter) RunWindow(ctx context.Context, w Window) error {
+	f.configure()
+	return f.acme.RunWindow(ctx, w)
+}
+
+// configure passes the options of the Formatter on to its Acme
+func (f *Formatter) configure() {
+	f.acme.Open = f.Open
+	if f.Record != "" {
+		f.acme.Open = RecordWindows(f.Record, f.Open)
+	}
+	f.acme.Reconnect = f.Reconnect
+	f.acme.Shadow = f.Shadow
+	f.acme.Errors = f.Errors
 }
 
 // exec executes commands that operate on stdin/stdout against the
-// Acme buffer
+// Acme buffer. Each command formats the output of the one before it.
 func (f *Formatter) exec(evt Event, cmds []Command, ext string) error {
-	updates := [][]byte{}
+	l := f.acme.Buf(evt.ID)
+	if l == nil {
+		return fmt.Errorf("no event loop found")
+	}
+	// the body is kept to find the edits made while formatting
+	base, err := l.Body()
+	if err != nil {
+		return err
+	}
+	new := base
 	for _, cmd := range cmds {
-		new, err := f.refmt(evt, cmd, ext)
+		new, err = f.refmt(evt.Context(), l, cmd, ext, new)
 		if err != nil {
 			return err
 		}
-		updates = append(updates, new)
 	}
-	return f.update(evt, updates)
+	return f.update(l, evt, base, new)
+}
+
+// report passes the error of the hook to the Errors handler
+func (f *Formatter) report(id int, file, hook string, err error) {
+	herr := &HookError{ID: id, File: file, Hook: hook, Err: err}
+	if f.Errors == nil {
+		StderrErrors(herr)
+		return
+	}
+	f.Errors(herr)
 }
 
 // fmt opens the Acme buffer for writing and applies the
 // indentation and tab expansion options provided in $NYNERULES
-func (f *Formatter) fmt(w *Win, ft Filetype) error {
+func (f *Formatter) fmt(w Window, ft Filetype) error {
 	if w == nil {
-		return fmt.Errorf("state has drifted: *Win is nil")
+		return fmt.Errorf("state has drifted: Window is nil")
 	}
 	if ft.Tabwidth == 0 {
 		return nil
 	}
-	if err := w.AppendTag("\n"); err != nil {
-		return err
-	}
 	if err := w.Exec("Tab", strconv.Itoa(ft.Tabwidth)); err != nil {
 		return err
 	}
@@ -132,20 +206,10 @@ func (f *Formatter) fmt(w *Win, ft Filetype) error {
 	return nil
 }
 
-// refmt executes a command to the Acme buffer and refreshes the buffer
-// with updated contents
-func (f *Formatter) refmt(evt Event, cmd Command, xt string) ([]byte, error) {
-	l := f.acme.Buf(evt.ID)
-	if l == nil {
-		return []byte{}, fmt.Errorf("no event loop found")
-	}
-
-	// get current body
-	old, err := l.Win().Body()
-	if err != nil {
-		return []byte{}, err
-	}
-
+// refmt executes a command on the body and returns the formatted
+// body. The command is killed when ctx is done.
+func (f *Formatter) refmt(ctx context.Context, l *Buf, cmd Command, xt string, old []byte) ([]byte, error) {
+	var err error
 	var nargs []string
 	var tmp *os.File
 	if cmd.PrintsToStdout {
@@ -166,9 +230,14 @@ func (f *Formatter) refmt(evt Event, cmd Command, xt string) ([]byte, error) {
 	}
 
 	// Execute the command
-	out, err := exec.Command(cmd.Exec, nargs...).CombinedOutput()
+	out, err := exec.CommandContext(ctx, cmd.Exec, nargs...).CombinedOutput()
 	if err != nil {
-		return []byte{}, fmt.Errorf("error: %+v\n%s", err, string(out))
+		if tmp != nil {
+			// keep the addresses in the output pointing at
+			// the window's file
+			out = bytes.ReplaceAll(out, []byte(tmp.Name()), []byte(l.File()))
+		}
+		return []byte{}, fmt.Errorf("%s: %v\n%s", cmd.Exec, err, out)
 	}
 
 	// handle formatting commands that both do and do not write to stdout
@@ -185,33 +254,38 @@ func (f *Formatter) refmt(evt Event, cmd Command, xt string) ([]byte, error) {
 	return new, nil
 }
 
-// update writes the updated contents to the file
-func (f *Formatter) update(evt Event, updates [][]byte) error {
-	l := f.acme.Buf(evt.ID)
-	if l == nil {
-		return fmt.Errorf("no event loop found")
-	}
+// update writes the formatted body to the window. Text typed while
+// the body was being formatted is kept, unless it was typed where the
+// formatting changed the body.
+func (f *Formatter) update(l *Buf, evt Event, base, new []byte) error {
 	w := l.Win()
-	for _, update := range updates {
-		if err := w.SetAddr(","); err != nil {
-			return err
-		}
-		if err := w.SetData(update); err != nil {
+	// undo the update at once
+	err := Transaction(w, func(w Window) error {
+		// the hook may have timed out while waiting for the lock
+		if err := evt.Context().Err(); err != nil {
 			return err
 		}
-		// prevent index out of bounds error
-		if w.Lastpoint > len(update) {
-			w.Lastpoint = len(update)
-		}
-		if err := w.SetAddr("#%d", w.Lastpoint); err != nil {
+		cur, err := w.Body()
+		if err != nil {
 			return err
 		}
-		if err := w.SelectionFromAddr(); err != nil {
-			return err
+		// only rewrite what changed so acme keeps the selection
+		// and scroll position
+		hunks := Diff(base, new)
+		if !bytes.Equal(base, cur) {
+			var ok bool
+			if hunks, ok = Rebase(base, cur, hunks); !ok {
+				return ErrEdited
+			}
 		}
-		if err := w.Show(); err != nil {
+		if err := applyHunks(w, hunks); err != nil {
 			return err
 		}
+		l.setPoint(Shift(l.point(), hunks))
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	w.WriteEvent(evt)
 	return nil
@@ -0,0 +1,253 @@
+package nyne
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnjp/nyne/acmetest"
+)
+
+func startFormatter(t *testing.T, ft Filetype, menu []string) *Formatter {
+	t.Helper()
+	f, err := NewFormatter([]Filetype{ft}, menu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go f.Run()
+	if err := fsrv.WaitLog(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFormatterNew(t *testing.T) {
+	defer fsrv.Reset()
+	startFormatter(t, Filetype{
+		Name:       "test",
+		Extensions: []string{".tst"},
+		Tabwidth:   2,
+		Tabexpand:  true,
+	}, []string{" |fmt"})
+
+	fw := fsrv.NewWindow("/tmp/new.tst", "")
+	waitFor(t, "tab width", func() bool {
+		return fw.Tabwidth() == 2
+	})
+	waitFor(t, "menu", func() bool {
+		return strings.Count(fw.Tag(), "|fmt") == 1
+	})
+}
+
+func TestFormatterTabexpand(t *testing.T) {
+	defer fsrv.Reset()
+	startFormatter(t, Filetype{
+		Name:       "test",
+		Extensions: []string{".tst"},
+		Tabwidth:   4,
+		Tabexpand:  true,
+	}, nil)
+
+	fw := fsrv.NewWindow("/tmp/tab.tst", "")
+	if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fw.Type("\t")
+	waitFor(t, "tab expansion", func() bool {
+		return fw.Body() == "    "
+	})
+}
+
+func TestFormatterPut(t *testing.T) {
+	defer fsrv.Reset()
+	dir, err := ioutil.TempDir("", "nyne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	startFormatter(t, Filetype{
+		Name:       "test",
+		Extensions: []string{".tst"},
+		Tabwidth:   8,
+		Commands: []Command{
+			{
+				Exec:           "sed",
+				Args:           []string{"-e", "s/before/after/", "$NAME"},
+				PrintsToStdout: true,
+			},
+		},
+	}, nil)
+
+	file := filepath.Join(dir, "put.tst")
+	fw := fsrv.NewWindow(file, "before")
+	if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// modify the file so that acme offers Put in the tag
+	fw.SetDot(6, 6)
+	fw.Type("\n")
+	fw.Exec("Put")
+	waitFor(t, "formatted body", func() bool {
+		return fw.Body() == "after\n"
+	})
+	waitFor(t, "formatted file", func() bool {
+		b, err := ioutil.ReadFile(file)
+		return err == nil && string(b) == "after\n"
+	})
+}
+
+func TestFormatterErrors(t *testing.T) {
+	defer fsrv.Reset()
+	dir, err := ioutil.TempDir("", "nyne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := NewFormatter([]Filetype{{
+		Name:       "test",
+		Extensions: []string{".tst"},
+		Tabwidth:   8,
+		Commands: []Command{
+			{
+				Exec: "sh",
+				Args: []string{"-c", `echo "$0:1: bad" >&2; exit 1`, "$NAME"},
+			},
+		},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Errors = AcmeErrors
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.RunContext(ctx) }()
+	// stop reporting before the windows are reset
+	defer func() {
+		cancel()
+		<-done
+	}()
+	if err := fsrv.WaitLog(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "put.tst")
+	fw := fsrv.NewWindow(file, "before")
+	if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fw.SetDot(6, 6)
+	fw.Type("\n")
+	fw.Exec("Put")
+	expected := file + ": Put: sh: exit status 1\n" + file + ":1: bad\n"
+	waitFor(t, "+Errors", func() bool {
+		ew := fsrv.Lookup(filepath.Join(dir, "+Errors"))
+		return ew != nil && strings.Contains(ew.Body(), expected)
+	})
+}
+
+func TestFormatterPutWhileTyping(t *testing.T) {
+	defer fsrv.Reset()
+	dir, err := ioutil.TempDir("", "nyne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	started := filepath.Join(dir, "started")
+	f, err := NewFormatter([]Filetype{{
+		Name:       "test",
+		Extensions: []string{".tst"},
+		Tabwidth:   2,
+		Tabexpand:  true,
+		Commands: []Command{
+			{
+				Exec:           "sh",
+				Args:           []string{"-c", `touch "$1"; sleep 0.5; sed s/before/after/ "$0"`, "$NAME", started},
+				PrintsToStdout: true,
+			},
+		},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan *HookError, 1)
+	f.Errors = func(err *HookError) {
+		if err.Hook == "Put" {
+			errs <- err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.RunContext(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	if err := fsrv.WaitLog(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(name string, q int, typed string) *acmetest.Window {
+		t.Helper()
+		os.Remove(started)
+		file := filepath.Join(dir, name)
+		fw := fsrv.NewWindow(file, "before")
+		if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		fw.SetDot(6, 6)
+		fw.Type("\n")
+		fw.Exec("Put")
+		waitFor(t, "the formatter to start", func() bool {
+			_, err := os.Stat(started)
+			return err == nil
+		})
+		fw.SetDot(q, q)
+		fw.Type(typed)
+		return fw
+	}
+
+	fw := put("typed.tst", 7, "TYPED")
+	waitFor(t, "formatted body", func() bool {
+		return fw.Body() == "after\nTYPED"
+	})
+	waitFor(t, "formatted file", func() bool {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "typed.tst"))
+		return err == nil && string(b) == "after\nTYPED"
+	})
+
+	fw = put("conflict.tst", 2, "X")
+	select {
+	case err := <-errs:
+		if err.Err != ErrEdited {
+			t.Fatalf("expected %v, got %v", ErrEdited, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the edit to be reported")
+	}
+	if body := fw.Body(); body != "beXfore\n" {
+		t.Fatalf("expected the typed text to be kept, got %q", body)
+	}
+
+	// tabs are expanded in the window while it is being formatted
+	fw = put("tab.tst", 7, "\t")
+	for i := 1; i <= 5; i++ {
+		waitFor(t, "expanded tab", func() bool {
+			return fw.Body() == "before\n"+strings.Repeat("  ", i)
+		})
+		fw.SetDot(7+2*i, 7+2*i)
+		if i < 5 {
+			fw.Type("\t")
+		}
+	}
+	expected := "after\n" + strings.Repeat("  ", 5)
+	waitFor(t, "formatted body with expanded tabs", func() bool {
+		return fw.Body() == expected
+	})
+}
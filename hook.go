@@ -11,4 +11,13 @@ type Condition func(Event) bool
 type Handler func(Event) (Event, bool)
 
 // WinHandler transforms the window
-type WinHandler func(*Win)
+type WinHandler func(Window)
+
+// LogHandler runs on operations read from the acme log
+type LogHandler func(LogEvent)
+
+// BufHandler runs on a Buf
+type BufHandler func(*Buf)
+
+// ErrorHandler receives the errors of hooks
+type ErrorHandler func(*HookError)
@@ -0,0 +1,157 @@
+package nyne
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// WinKind is the kind of text an acme window holds
+type WinKind int
+
+const (
+	// WinFile is a file on disk
+	WinFile WinKind = iota
+	// WinDir is a directory listing
+	WinDir
+	// WinErrors is the +Errors window of a directory
+	WinErrors
+	// WinTerm is a terminal run by win, named -host after the
+	// machine it runs on
+	WinTerm
+	// WinScratch is text that is not a file, such as a window with no
+	// name, a +name window or the output of a tool
+	WinScratch
+	// WinGuide is a guide file of commands to execute
+	WinGuide
+)
+
+var kindNames = map[WinKind]string{
+	WinFile:    "file",
+	WinDir:     "directory",
+	WinErrors:  "errors",
+	WinTerm:    "terminal",
+	WinScratch: "scratch",
+	WinGuide:   "guide",
+}
+
+// String returns the name of the kind
+func (k WinKind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// IsFile reports whether the window holds a file that can be
+// formatted and written with Put
+func (k WinKind) IsFile() bool {
+	return k == WinFile || k == WinGuide
+}
+
+// WinRule sets the kind of the windows whose name matches Pattern
+type WinRule struct {
+	// Pattern is matched with path.Match against the name of the
+	// window and against the last element of the name
+	Pattern string
+	Kind    WinKind
+}
+
+// match reports whether the name matches the rule
+func (r WinRule) match(name string) bool {
+	if ok, _ := path.Match(r.Pattern, name); ok {
+		return true
+	}
+	ok, _ := path.Match(r.Pattern, path.Base(name))
+	return ok
+}
+
+// WinInfo is what acme reports about a window in its index, ctl and
+// tag files
+type WinInfo struct {
+	ID    int
+	Name  string
+	IsDir bool
+	Tag   Tag
+}
+
+// Classify returns the kind of the window. The first of WinRules that
+// matches the name decides the kind, and otherwise it is worked out
+// from the name, the tag and whether acme lists the window as a
+// directory.
+func Classify(info WinInfo) WinKind {
+	return classify(info, WinRules)
+}
+
+func classify(info WinInfo, rules []WinRule) WinKind {
+	name := info.Name
+	for _, r := range rules {
+		if r.match(name) {
+			return r.Kind
+		}
+	}
+	if info.IsDir || strings.HasSuffix(name, "/") {
+		return WinDir
+	}
+	base := path.Base(name)
+	switch {
+	case base == "+Errors":
+		return WinErrors
+	case name == "" || strings.HasPrefix(name, "+") || strings.HasPrefix(base, "+"):
+		return WinScratch
+	case strings.HasPrefix(base, "-"):
+		// win adds Send to the tag of the terminals it runs
+		if info.Tag.Has("Send") || isHost(base[1:]) {
+			return WinTerm
+		}
+		return WinScratch
+	case base == "guide":
+		return WinGuide
+	}
+	return WinFile
+}
+
+// isHost reports whether name is the name win gives terminals on this
+// machine
+func isHost(name string) bool {
+	host, err := os.Hostname()
+	if err != nil || name == "" {
+		return false
+	}
+	return name == host || strings.HasPrefix(host, name+".")
+}
+
+// Info reads what acme reports about the window from its ctl and tag
+// files
+func (w *Win) Info() (WinInfo, error) {
+	ctl, err := w.readAll("ctl")
+	if err != nil {
+		return WinInfo{}, err
+	}
+	tag, err := w.Tag()
+	if err != nil {
+		return WinInfo{}, err
+	}
+	t := ParseTag(tag)
+	name := t.File
+	if name == "" {
+		name = w.file
+	}
+	// the fourth field of ctl is 1 for directories
+	f := strings.Fields(string(ctl))
+	return WinInfo{
+		ID:    w.id,
+		Name:  name,
+		IsDir: len(f) > 3 && f[3] == "1",
+		Tag:   t,
+	}, nil
+}
+
+// Kind classifies the window with Classify
+func (w *Win) Kind() (WinKind, error) {
+	info, err := w.Info()
+	if err != nil {
+		return 0, err
+	}
+	return Classify(info), nil
+}
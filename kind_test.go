@@ -0,0 +1,72 @@
+package nyne
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	rules := []WinRule{
+		{Pattern: "*xplor*", Kind: WinScratch},
+		{Pattern: "/tmp/notes/*", Kind: WinGuide},
+	}
+	testCases := []struct {
+		info     WinInfo
+		expected WinKind
+	}{
+		{WinInfo{Name: "/tmp/a.go"}, WinFile},
+		{WinInfo{Name: "/tmp/a/"}, WinDir},
+		{WinInfo{Name: "/tmp/a", IsDir: true}, WinDir},
+		{WinInfo{Name: "/tmp/+Errors"}, WinErrors},
+		{WinInfo{Name: "+Errors"}, WinErrors},
+		{WinInfo{Name: "/tmp/-box", Tag: ParseTag([]byte("/tmp/-box Del Snarf | Look Send Noscroll"))}, WinTerm},
+		{WinInfo{Name: "/tmp/-out"}, WinScratch},
+		{WinInfo{Name: ""}, WinScratch},
+		{WinInfo{Name: "+nyne/trace"}, WinScratch},
+		{WinInfo{Name: "/tmp/+watch"}, WinScratch},
+		{WinInfo{Name: "/tmp/guide"}, WinGuide},
+		{WinInfo{Name: "/tmp/xplor"}, WinScratch},
+		{WinInfo{Name: "/tmp/notes/todo"}, WinGuide},
+	}
+	for _, tc := range testCases {
+		if kind := classify(tc.info, rules); kind != tc.expected {
+			t.Errorf("%q: expected %v, got %v", tc.info.Name, tc.expected, kind)
+		}
+	}
+}
+
+func TestWinKind(t *testing.T) {
+	defer fsrv.Reset()
+	testCases := []struct {
+		name     string
+		tag      string
+		expected WinKind
+	}{
+		{"/tmp/kind.go", "", WinFile},
+		{"/tmp/kind/", "", WinDir},
+		{"/tmp/-box", " Send", WinTerm},
+	}
+	for _, tc := range testCases {
+		fw := fsrv.NewWindow(tc.name, "")
+		w, err := OpenWin(fw.ID(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AppendTag(tc.tag); err != nil {
+			t.Fatal(err)
+		}
+		kind, err := w.Kind()
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, kind)
+		}
+
+		ws, err := index()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := Classify(ws[fw.ID()]); kind != tc.expected {
+			t.Errorf("%s: expected %v from the index, got %v", tc.name, tc.expected, kind)
+		}
+	}
+}
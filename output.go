@@ -0,0 +1,187 @@
+package nyne
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OutputMode is what OutputWin does with a window that is already open
+type OutputMode int
+
+const (
+	// OutputClear empties the body of the window
+	OutputClear OutputMode = iota
+	// OutputAppend keeps the body and moves dot to its end
+	OutputAppend
+	// OutputReuse leaves the window as it is
+	OutputReuse
+)
+
+// OutputOptions configures the window returned by OutputWin
+type OutputOptions struct {
+	Mode OutputMode
+	// Dump is the command that recreates the window from a dump
+	// file, run in Dumpdir
+	Dump    string
+	Dumpdir string
+}
+
+// Output is a window that a tool writes its output to. It follows the
+// acme log until the window is deleted or the Output is closed.
+type Output struct {
+	*Win
+	c       *conn
+	done    chan struct{}
+	mu      sync.Mutex
+	deleted bool
+}
+
+// OutputWin returns the window named name, creating it if there is no
+// such window, for writing output to
+func OutputWin(name string, opts OutputOptions) (*Output, error) {
+	// the log is opened first so that a deletion is not missed
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	l, err := openLog(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	w, existed, err := outputWin(name)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	o := &Output{Win: w, c: c, done: make(chan struct{})}
+	go o.track(l)
+
+	if err := o.setup(existed, opts); err != nil {
+		o.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// outputWin opens the window named name or creates it
+func outputWin(name string) (w *Win, existed bool, err error) {
+	ws, err := windows()
+	if err != nil {
+		return nil, false, err
+	}
+	id := -1
+	for wid, wname := range ws {
+		if wname == name && (id < 0 || wid < id) {
+			id = wid
+		}
+	}
+	if id >= 0 {
+		w, err = OpenWin(id, name)
+		return w, true, err
+	}
+	w, err = NewWin()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := w.Name("%s", name); err != nil {
+		w.Close()
+		return nil, false, err
+	}
+	return w, false, nil
+}
+
+func (o *Output) setup(existed bool, opts OutputOptions) error {
+	if existed {
+		switch opts.Mode {
+		case OutputClear:
+			if err := o.ClearBody(); err != nil {
+				return err
+			}
+		case OutputAppend:
+			if err := o.SetAddr("$"); err != nil {
+				return err
+			}
+			if err := o.SelectionFromAddr(); err != nil {
+				return err
+			}
+		case OutputReuse:
+		default:
+			return fmt.Errorf("unknown output mode %d", opts.Mode)
+		}
+	}
+	if opts.Dump != "" {
+		return o.SetDump(opts.Dump, opts.Dumpdir)
+	}
+	return nil
+}
+
+// track closes done once the window is deleted or the log can no
+// longer be read
+func (o *Output) track(l *logReader) {
+	defer close(o.done)
+	for {
+		e, err := l.Read()
+		if err != nil {
+			return
+		}
+		if e.ID == o.ID() && e.Op == Del {
+			o.mu.Lock()
+			o.deleted = true
+			o.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Done returns a channel that is closed when the window is deleted or
+// the Output is closed
+func (o *Output) Done() <-chan struct{} {
+	return o.done
+}
+
+// Deleted reports whether the window has been deleted
+func (o *Output) Deleted() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.deleted
+}
+
+// Write appends p to the body
+func (o *Output) Write(p []byte) (int, error) {
+	if err := o.AppendBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Top moves dot to the start of the body and scrolls the window to it
+func (o *Output) Top() error {
+	if err := o.SetAddr("#0"); err != nil {
+		return err
+	}
+	if err := o.SelectionFromAddr(); err != nil {
+		return err
+	}
+	return o.Show()
+}
+
+// SetDump sets the command and the directory it runs in that recreate
+// the window from a dump file
+func (o *Output) SetDump(cmd, dir string) error {
+	if err := o.Dump(cmd); err != nil {
+		return err
+	}
+	if dir == "" {
+		return nil
+	}
+	return o.Dumpdir(dir)
+}
+
+// Close stops following the log and closes the files of the window
+// without deleting it
+func (o *Output) Close() {
+	o.c.Close()
+	o.Win.Close()
+	<-o.done
+}
@@ -0,0 +1,61 @@
+package nyne
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutputWin(t *testing.T) {
+	defer fsrv.Reset()
+	name := "/tmp/-out"
+	o, err := OutputWin(name, OutputOptions{Dump: "out -x", Dumpdir: "/tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	fw := fsrv.Lookup(name)
+	if fw == nil {
+		t.Fatalf("expected a window named %s", name)
+	}
+	if cmd, dir := fw.Dump(); cmd != "out -x" || dir != "/tmp" {
+		t.Fatalf("expected dump %q in %q, got %q in %q", "out -x", "/tmp", cmd, dir)
+	}
+	if _, err := o.Write([]byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		mode     OutputMode
+		expected string
+	}{
+		{OutputReuse, "one\n"},
+		{OutputAppend, "one\n"},
+		{OutputClear, ""},
+	}
+	for _, tc := range testCases {
+		r, err := OutputWin(name, OutputOptions{Mode: tc.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		if r.ID() != fw.ID() {
+			t.Fatalf("expected window %d to be reused, got %d", fw.ID(), r.ID())
+		}
+		if fw.Body() != tc.expected {
+			t.Fatalf("mode %d: expected body %q, got %q", tc.mode, tc.expected, fw.Body())
+		}
+	}
+	if n := len(fsrv.Windows()); n != 1 {
+		t.Fatalf("expected 1 window, got %d", n)
+	}
+
+	fsrv.Delete(fw.ID())
+	select {
+	case <-o.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the window to be deleted")
+	}
+	if !o.Deleted() {
+		t.Fatal("expected the window to be deleted")
+	}
+}
@@ -0,0 +1,206 @@
+package nyne
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RecordOp is what a Record records
+type RecordOp string
+
+const (
+	// RecordBody is the body of the window when recording started
+	RecordBody RecordOp = "body"
+	// RecordEvent is an event read from acme
+	RecordEvent RecordOp = "event"
+	// RecordWrite is an event written back to acme
+	RecordWrite RecordOp = "write"
+	// RecordAddr is an address set by nyne
+	RecordAddr RecordOp = "addr"
+	// RecordData is text written to the address by nyne
+	RecordData RecordOp = "data"
+	// RecordEnd is the body of the window when it was deleted or
+	// recording stopped
+	RecordEnd RecordOp = "end"
+)
+
+// Record is a line of a recording of a window
+type Record struct {
+	Op    RecordOp  `json:"op"`
+	Time  time.Time `json:"time"`
+	File  string    `json:"file,omitempty"`
+	Event *Event    `json:"event,omitempty"`
+	Addr  string    `json:"addr,omitempty"`
+	Data  string    `json:"data,omitempty"`
+}
+
+// Recorder is a Window that records the events read from it and the
+// edits made to it as JSON lines, so that they can be replayed with
+// Replay
+type Recorder struct {
+	Window
+	mu  sync.Mutex
+	out io.WriteCloser
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder constructs a Recorder of the window that writes to out,
+// starting with the body of the window
+func NewRecorder(w Window, out io.WriteCloser) (*Recorder, error) {
+	body, err := w.Body()
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{Window: w, out: out, enc: json.NewEncoder(out)}
+	r.record(Record{Op: RecordBody, File: w.File(), Data: string(body)})
+	return r, r.Err()
+}
+
+// RecordWindows returns a WinOpener that records each window opened
+// with open to its own file in dir
+func RecordWindows(dir string, open WinOpener) WinOpener {
+	return func(id int, file string) (Window, error) {
+		w, err := open(id, file)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%d-%s.jsonl", id, Filename(file))
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		r, err := NewRecorder(w, f)
+		if err != nil {
+			f.Close()
+			w.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+}
+
+// Err returns the first error writing the recording
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(rec Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	rec.Time = time.Now()
+	r.err = r.enc.Encode(rec)
+}
+
+// EventChan records the events of the window as they are read
+func (r *Recorder) EventChan(stop <-chan struct{}) (<-chan Event, <-chan error) {
+	in, errs := r.Window.EventChan(stop)
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		for e := range in {
+			e := e
+			r.record(Record{Op: RecordEvent, Event: &e})
+			select {
+			case events <- e:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return events, errs
+}
+
+// WriteEvent records the event and writes it back to acme. The body is
+// recorded before writing back an event that deletes the window, as it
+// can no longer be read once the window is gone.
+func (r *Recorder) WriteEvent(e Event) error {
+	r.record(Record{Op: RecordWrite, Event: &e})
+	if isDel(e) {
+		r.end()
+	}
+	return r.Window.WriteEvent(e)
+}
+
+// isDel reports whether writing the event back deletes the window
+func isDel(e Event) bool {
+	if e.Origin == DelOrigin && e.Action == DelAction {
+		return true
+	}
+	return e.IsExec() && (e.Text == Del || e.Text == "Delete")
+}
+
+// SetAddr records the address and sets it
+func (r *Recorder) SetAddr(fmtstr string, args ...interface{}) error {
+	a := fmtstr
+	if len(args) > 0 {
+		a = fmt.Sprintf(fmtstr, args...)
+	}
+	r.record(Record{Op: RecordAddr, Addr: a})
+	return r.Window.SetAddr(fmtstr, args...)
+}
+
+// SetData records the text and writes it to the address
+func (r *Recorder) SetData(data []byte) error {
+	r.record(Record{Op: RecordData, Data: string(data)})
+	return r.Window.SetData(data)
+}
+
+// ClearBody records the body being replaced with nothing and clears it
+func (r *Recorder) ClearBody() error {
+	r.record(Record{Op: RecordAddr, Addr: ","})
+	r.record(Record{Op: RecordData})
+	return r.Window.ClearBody()
+}
+
+// AppendBody records the text being written at the end of the body and
+// appends it
+func (r *Recorder) AppendBody(data []byte) error {
+	r.record(Record{Op: RecordAddr, Addr: "$"})
+	r.record(Record{Op: RecordData, Data: string(data)})
+	return r.Window.AppendBody(data)
+}
+
+// end records the body the window has now. Replay compares against
+// the last body recorded, so a window that is still open when Del is
+// refused is recorded again when it is closed.
+func (r *Recorder) end() {
+	if body, err := r.Window.Body(); err == nil {
+		r.record(Record{Op: RecordEnd, Data: string(body)})
+	}
+}
+
+// Close records the body of the window if it is still open and closes
+// both the window and the recording
+func (r *Recorder) Close() {
+	r.end()
+	r.Window.Close()
+	r.out.Close()
+}
+
+// ReadRecording reads the records written by a Recorder
+func ReadRecording(in io.Reader) ([]Record, error) {
+	var records []Record
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, 64<<20)
+	for n := 1; s.Scan(); n++ {
+		var rec Record
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		records = append(records, rec)
+	}
+	return records, s.Err()
+}
@@ -0,0 +1,219 @@
+package nyne
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventJSON(t *testing.T) {
+	e := Event{Origin: Keyboard, Action: BodyInsert, Text: "é", SelBegin: 1, SelEnd: 2}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, e) {
+		t.Fatalf("expected %+v, got %+v", e, got)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	defer fsrv.Reset()
+	dir, err := ioutil.TempDir("", "nyne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ft := Filetype{
+		Name:       "test",
+		Extensions: []string{".tst"},
+		Tabwidth:   2,
+		Tabexpand:  true,
+	}
+	f, err := NewFormatter([]Filetype{ft}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Record = dir
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.RunContext(ctx) }()
+	if err := fsrv.WaitLog(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw := fsrv.NewWindow("/tmp/record.tst", "a\nb\n")
+	if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// dirtying the window adds Put to the tag, which would move the
+	// commands the New hooks are running in it
+	waitFor(t, "the New hooks", func() bool {
+		ex := fw.Executed()
+		user := ParseTag([]byte(fw.Tag())).User
+		return len(ex) > 0 && ex[len(ex)-1] == "Tab 2" && user == " Look "
+	})
+	fw.SetDot(2, 2)
+	fw.Type("\tc")
+	waitFor(t, "expanded tab", func() bool {
+		return fw.Body() == "a\n  cb\n"
+	})
+	// the body is recorded before the window is deleted
+	fw.Exec("Delete")
+	waitFor(t, "the recording to stop", func() bool {
+		return fsrv.Lookup("/tmp/record.tst") == nil && f.acme.Buf(fw.ID()) == nil
+	})
+	cancel()
+	<-done
+
+	in, err := os.Open(filepath.Join(dir, fmt.Sprintf("%d-record.tst.jsonl", fw.ID())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	records, err := ReadRecording(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := make(map[RecordOp]int)
+	for _, rec := range records {
+		ops[rec.Op]++
+	}
+	if ops[RecordBody] != 1 || ops[RecordEnd] != 1 || ops[RecordEvent] == 0 || ops[RecordData] == 0 {
+		t.Fatalf("unexpected recording %v", ops)
+	}
+	if end := records[len(records)-1]; end.Op != RecordEnd || end.Data != "a\n  cb\n" {
+		t.Fatalf("unexpected end of recording %+v", end)
+	}
+
+	replay := func(ft Filetype) []Hunk {
+		t.Helper()
+		f, err := NewFormatter([]Filetype{ft}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw := fsrv.NewWindow("/tmp/replay.tst", "")
+		w, err := OpenWin(fw.ID(), "/tmp/replay.tst")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		hunks, err := Replay(context.Background(), w, records, f.RunWindow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hunks
+	}
+	if hunks := replay(ft); len(hunks) != 0 {
+		t.Fatalf("expected replay to match the recording, got %v", hunks)
+	}
+	ft.Tabexpand = false
+	expected := []Hunk{{2, 3, []byte("  ")}}
+	if hunks := replay(ft); !reflect.DeepEqual(hunks, expected) {
+		t.Fatalf("expected %v, got %v", expected, hunks)
+	}
+}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestRecorderSetAddr(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/addr.txt", "a 50% b\n")
+	w, err := OpenWin(fw.ID(), "/tmp/addr.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := nopCloser{new(bytes.Buffer)}
+	r, err := NewRecorder(w, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// addresses are only formatted when there are arguments, as
+	// with Win.SetAddr
+	setAddr := r.SetAddr
+	if err := setAddr("/50%/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetAddr("#%d", 7); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadRecording(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addrs []string
+	for _, rec := range records {
+		if rec.Op == RecordAddr {
+			addrs = append(addrs, rec.Addr)
+		}
+	}
+	if expected := []string{"/50%/", "#7"}; !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expected %q, got %q", expected, addrs)
+	}
+	if err := setAddr("/50%/"); err != nil {
+		t.Fatal(err)
+	}
+	if q0, q1, err := r.Addr(); err != nil || q0 != 2 || q1 != 5 {
+		t.Fatalf("expected 2,5, got %d,%d: %v", q0, q1, err)
+	}
+}
+
+func TestRecorderBodyEdits(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/edits.txt", "a\n")
+	w, err := OpenWin(fw.ID(), "/tmp/edits.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := nopCloser{new(bytes.Buffer)}
+	r, err := NewRecorder(w, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.ClearBody(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AppendBody([]byte("b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fw.Body() != "b\n" {
+		t.Fatalf("expected body %q, got %q", "b\n", fw.Body())
+	}
+	records, err := ReadRecording(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edits []string
+	for _, rec := range records {
+		switch rec.Op {
+		case RecordAddr:
+			edits = append(edits, rec.Addr)
+		case RecordData:
+			edits = append(edits, rec.Data)
+		}
+	}
+	expected := []string{",", "", "$", "b\n"}
+	if !reflect.DeepEqual(edits, expected) {
+		t.Fatalf("expected edits %q, got %q", expected, edits)
+	}
+}
@@ -0,0 +1,92 @@
+package nyne
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry is an index of running Bufs by window ID and file name
+// that is safe for concurrent use
+type Registry struct {
+	mu   sync.RWMutex
+	bufs map[int]*Buf
+}
+
+// NewRegistry constructs an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		bufs: make(map[int]*Buf),
+	}
+}
+
+// Add registers the Buf under its window ID. It returns false if a
+// Buf is already registered for the window.
+func (r *Registry) Add(b *Buf) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.bufs[b.id]; ok {
+		return false
+	}
+	r.bufs[b.id] = b
+	return true
+}
+
+// Remove unregisters the Buf. It returns false if the Buf is no
+// longer registered, so that a Buf replaced by a newer one with the
+// same window ID is left in place.
+func (r *Registry) Remove(b *Buf) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.bufs[b.id] != b {
+		return false
+	}
+	delete(r.bufs, b.id)
+	return true
+}
+
+// Buf returns the Buf for the window ID or nil if there is none
+func (r *Registry) Buf(id int) *Buf {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bufs[id]
+}
+
+// Lookup returns the Bufs open on the file ordered by window ID.
+// More than one window may show the same file after a Zerox.
+func (r *Registry) Lookup(file string) []*Buf {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var bufs []*Buf
+	for _, b := range r.bufs {
+		if b.file == file {
+			bufs = append(bufs, b)
+		}
+	}
+	sortBufs(bufs)
+	return bufs
+}
+
+// Bufs returns all registered Bufs ordered by window ID
+func (r *Registry) Bufs() []*Buf {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	bufs := make([]*Buf, 0, len(r.bufs))
+	for _, b := range r.bufs {
+		bufs = append(bufs, b)
+	}
+	sortBufs(bufs)
+	return bufs
+}
+
+// Len returns the number of registered Bufs
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.bufs)
+}
+
+func sortBufs(bufs []*Buf) {
+	sort.Slice(bufs, func(i, j int) bool {
+		return bufs[i].id < bufs[j].id
+	})
+}
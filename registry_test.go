@@ -0,0 +1,49 @@
+package nyne
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	a := NewBuf(1, "/tmp/a.txt")
+	b := NewBuf(2, "/tmp/b.txt")
+	zerox := NewBuf(3, "/tmp/a.txt")
+
+	var wg sync.WaitGroup
+	for _, buf := range []*Buf{zerox, b, a} {
+		wg.Add(1)
+		go func(buf *Buf) {
+			defer wg.Done()
+			if !r.Add(buf) {
+				t.Errorf("could not add buf %d", buf.id)
+			}
+		}(buf)
+	}
+	wg.Wait()
+
+	if r.Add(NewBuf(1, "/tmp/a.txt")) {
+		t.Fatal("expected duplicate ID to be rejected")
+	}
+	if r.Buf(2) != b {
+		t.Fatal("expected lookup by ID to return buf 2")
+	}
+	bufs := r.Lookup("/tmp/a.txt")
+	if len(bufs) != 2 || bufs[0] != a || bufs[1] != zerox {
+		t.Fatalf("unexpected bufs for file: %v", bufs)
+	}
+
+	if r.Remove(NewBuf(2, "/tmp/b.txt")) {
+		t.Fatal("expected a stale Buf not to be removed")
+	}
+	if !r.Remove(b) {
+		t.Fatal("expected buf 2 to be removed")
+	}
+	if r.Remove(b) {
+		t.Fatal("expected buf 2 to be removed once")
+	}
+	if r.Buf(2) != nil || r.Len() != 2 {
+		t.Fatalf("unexpected registry after removal: %v", r.Bufs())
+	}
+}
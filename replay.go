@@ -0,0 +1,228 @@
+package nyne
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoEnd is returned by Replay when the body of the window could not
+// be read when the recording stopped, leaving no body to compare
+// against
+var ErrNoEnd = errors.New("recording has no final body")
+
+// Replayer is a Window that sends the events a user made in a
+// recording in place of the window's own. The edits acme made for the
+// events, such as typed text, are applied to the window before each
+// event is sent, while the edits the hooks made are left to the hooks
+// being replayed.
+//
+// Events written back are dropped, so that builtins such as Put are
+// not run again.
+type Replayer struct {
+	Window
+	// Wait is how long to wait for the hooks to make the edits they
+	// made for an event in the recording before sending the next one
+	Wait    time.Duration
+	records []Record
+	mu      sync.Mutex
+	edits   int
+	edited  chan struct{}
+	done    chan struct{}
+}
+
+// NewReplayer constructs a Replayer of the recording that edits w. The
+// body of w is replaced with the body the recording started with.
+func NewReplayer(w Window, records []Record) (*Replayer, error) {
+	for _, rec := range records {
+		if rec.Op != RecordBody {
+			continue
+		}
+		if err := w.SetAddr(","); err != nil {
+			return nil, err
+		}
+		if err := w.SetData([]byte(rec.Data)); err != nil {
+			return nil, err
+		}
+		break
+	}
+	return &Replayer{
+		Window:  w,
+		Wait:    5 * time.Second,
+		records: records,
+		edited:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// isInput reports whether the event was made by the user rather than
+// by nyne's own edits
+func isInput(e *Event) bool {
+	return e != nil && (e.Origin == Keyboard || e.Origin == Mouse)
+}
+
+// EventChan sends the events of the recording. The channel is closed
+// once every event has been sent and its edits made, as if the window
+// had been deleted.
+func (r *Replayer) EventChan(stop <-chan struct{}) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(r.done)
+		defer close(events)
+		for i, rec := range r.records {
+			if rec.Op != RecordEvent || !isInput(rec.Event) {
+				continue
+			}
+			e := *rec.Event
+			e.ID, e.File = r.ID(), r.File()
+			if err := r.apply(e); err != nil {
+				errs <- err
+				return
+			}
+			want := r.count() + edits(r.records[i+1:])
+			select {
+			case events <- e:
+			case <-stop:
+				return
+			}
+			if !r.wait(want, stop) {
+				return
+			}
+		}
+	}()
+	return events, errs
+}
+
+// edits returns the number of edits recorded before the next input
+func edits(records []Record) int {
+	n := 0
+	for _, rec := range records {
+		if rec.Op == RecordEvent && isInput(rec.Event) {
+			break
+		}
+		if rec.Op == RecordData {
+			n++
+		}
+	}
+	return n
+}
+
+// apply makes the edit acme made to the body before sending the event
+func (r *Replayer) apply(e Event) error {
+	r.Window.Lock()
+	defer r.Window.Unlock()
+	var text []byte
+	switch e.Action {
+	case BodyInsert:
+		if err := r.Window.SetAddr("#%d", e.SelBegin); err != nil {
+			return err
+		}
+		text = e.Text.Bytes()
+	case BodyDelete:
+		if err := r.Window.SetAddr("#%d,#%d", e.SelBegin, e.SelEnd); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	return r.Window.SetData(text)
+}
+
+func (r *Replayer) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.edits
+}
+
+// wait waits until n edits have been made or Wait has passed. It
+// returns false if stop was closed.
+func (r *Replayer) wait(n int, stop <-chan struct{}) bool {
+	timeout := time.After(r.Wait)
+	for r.count() < n {
+		select {
+		case <-r.edited:
+		case <-timeout:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+// SetData counts the edit and makes it
+func (r *Replayer) SetData(data []byte) error {
+	err := r.Window.SetData(data)
+	r.edit()
+	return err
+}
+
+// ClearBody counts the edit and makes it
+func (r *Replayer) ClearBody() error {
+	err := r.Window.ClearBody()
+	r.edit()
+	return err
+}
+
+// AppendBody counts the edit and makes it
+func (r *Replayer) AppendBody(data []byte) error {
+	err := r.Window.AppendBody(data)
+	r.edit()
+	return err
+}
+
+// edit counts an edit made by the hooks
+func (r *Replayer) edit() {
+	r.mu.Lock()
+	r.edits++
+	r.mu.Unlock()
+	select {
+	case r.edited <- struct{}{}:
+	default:
+	}
+}
+
+// WriteEvent drops the event
+func (r *Replayer) WriteEvent(e Event) error {
+	return nil
+}
+
+// Close leaves the window open so that its body can be compared once
+// the replay is over
+func (r *Replayer) Close() {}
+
+// Done returns a channel that is closed once every event has been sent
+func (r *Replayer) Done() <-chan struct{} {
+	return r.done
+}
+
+// Replay plays the recording against w with run, which runs the hooks
+// being tested on the Window it is given until its events end. It
+// returns the hunks that turn the resulting body into the body the
+// recording ended with, which are empty if the hooks did what they did
+// when it was recorded.
+func Replay(ctx context.Context, w Window, records []Record, run func(context.Context, Window) error) ([]Hunk, error) {
+	var end *Record
+	for i := range records {
+		if records[i].Op == RecordEnd {
+			end = &records[i]
+		}
+	}
+	if end == nil {
+		return nil, ErrNoEnd
+	}
+	r, err := NewReplayer(w, records)
+	if err != nil {
+		return nil, err
+	}
+	if err := run(ctx, r); err != nil {
+		return nil, err
+	}
+	body, err := w.Body()
+	if err != nil {
+		return nil, err
+	}
+	return Diff(body, []byte(end.Data)), nil
+}
@@ -0,0 +1,200 @@
+package nyne
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Route selects the events a hook runs on. The zero value of each
+// field matches every event.
+type Route struct {
+	// Name identifies the hook. Registering a hook with the name of
+	// one already registered replaces it.
+	Name string
+	// Priority orders the hooks, lowest first. Hooks of the same
+	// priority run in the order they were registered.
+	Priority int
+	// Text is the text of the event, such as Put or New
+	Text Text
+	// Key is the character typed into the body
+	Key    rune
+	Origin Origin
+	Action Action
+	// Extensions are the extensions of the files, as returned by
+	// Extension, the hook runs on
+	Extensions []string
+}
+
+// match reports whether the event is selected by the route
+func (r *Route) match(e Event) bool {
+	if r.Text != "" && r.Text != e.Text {
+		return false
+	}
+	if r.Key != 0 {
+		if e.Origin != Keyboard || e.Action != BodyInsert {
+			return false
+		}
+		if c, _ := utf8.DecodeRune(e.Text.Bytes()); c != r.Key {
+			return false
+		}
+	}
+	if r.Origin != 0 && r.Origin != e.Origin {
+		return false
+	}
+	if r.Action != 0 && r.Action != e.Action {
+		return false
+	}
+	if len(r.Extensions) > 0 {
+		ext := Extension(e.File, "")
+		for _, x := range r.Extensions {
+			if x == ext {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Router runs the hooks registered on it in order of priority. It is
+// safe for concurrent use, so hooks can be added and removed while
+// events are being routed.
+type Router struct {
+	mu    sync.RWMutex
+	seq   int
+	hooks []*Registration
+}
+
+// Registration is a hook registered on a Router
+type Registration struct {
+	Route
+	r   *Router
+	seq int
+	h   Handler
+	wh  WinHandler
+}
+
+// NewRouter constructs a Router with no hooks
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers the Handler for the events selected by the route
+func (r *Router) Handle(route Route, h Handler) *Registration {
+	return r.add(&Registration{Route: route, h: h})
+}
+
+// HandleWin registers the WinHandler for the window events selected
+// by the route
+func (r *Router) HandleWin(route Route, h WinHandler) *Registration {
+	return r.add(&Registration{Route: route, wh: h})
+}
+
+func (r *Router) add(reg *Registration) *Registration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reg.r = r
+	r.seq++
+	reg.seq = r.seq
+	hooks := r.hooks[:0:0]
+	for _, h := range r.hooks {
+		if reg.Name == "" || h.Name != reg.Name {
+			hooks = append(hooks, h)
+		}
+	}
+	hooks = append(hooks, reg)
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if hooks[i].Priority != hooks[j].Priority {
+			return hooks[i].Priority < hooks[j].Priority
+		}
+		return hooks[i].seq < hooks[j].seq
+	})
+	// hooks are copied so that routing can read them unlocked
+	r.hooks = hooks
+	return reg
+}
+
+// Remove unregisters the hook. It returns false if the hook was
+// already removed or replaced.
+func (reg *Registration) Remove() bool {
+	r := reg.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, h := range r.hooks {
+		if h == reg {
+			hooks := make([]*Registration, 0, len(r.hooks)-1)
+			hooks = append(hooks, r.hooks[:i]...)
+			r.hooks = append(hooks, r.hooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Remove unregisters the hook with the name. It returns false if
+// there is no such hook.
+func (r *Router) Remove(name string) bool {
+	for _, h := range r.list() {
+		if h.Name == name {
+			return h.Remove()
+		}
+	}
+	return false
+}
+
+// Hooks returns the registered hooks in the order they run
+func (r *Router) Hooks() []*Registration {
+	return append([]*Registration(nil), r.list()...)
+}
+
+func (r *Router) list() []*Registration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.hooks
+}
+
+// Event runs the Handlers selected for the event, passing each the
+// event returned by the one before it. A Handler that returns false
+// stops the chain, and Event returns its event and false.
+func (r *Router) Event(e Event) (Event, bool) {
+	return r.event(e, nil)
+}
+
+func (r *Router) event(e Event, tr Tracer) (Event, bool) {
+	for _, h := range r.list() {
+		if h.h == nil || !h.match(e) {
+			continue
+		}
+		var ok bool
+		if e, ok = tr.event(h.name(), h.h, e); !ok {
+			return e, false
+		}
+	}
+	return e, true
+}
+
+// Win runs the WinHandlers selected for the window event
+func (r *Router) Win(w Window, e Event) {
+	r.win(e, func(name string, h WinHandler) {
+		h(w)
+	})
+}
+
+// win calls run with each WinHandler selected for the window event
+func (r *Router) win(e Event, run func(name string, h WinHandler)) {
+	for _, h := range r.list() {
+		if h.wh != nil && h.match(e) {
+			run(h.name(), h.wh)
+		}
+	}
+}
+
+// name returns the name of the hook for traces
+func (reg *Registration) name() string {
+	if reg.Name == "" {
+		return fmt.Sprintf("route %d", reg.seq)
+	}
+	return reg.Name
+}
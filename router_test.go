@@ -0,0 +1,108 @@
+package nyne
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouterOrder(t *testing.T) {
+	r := NewRouter()
+	var ran []string
+	hook := func(name string, ok bool) Handler {
+		return func(e Event) (Event, bool) {
+			ran = append(ran, name)
+			return e, ok
+		}
+	}
+	r.Handle(Route{Name: "b", Priority: 1}, hook("b", true))
+	r.Handle(Route{Name: "a"}, hook("a", true))
+	c := r.Handle(Route{Name: "c", Priority: 1}, hook("c", true))
+	r.Handle(Route{Name: "stop", Priority: 2}, hook("stop", false))
+	r.Handle(Route{Name: "d", Priority: 3}, hook("d", true))
+
+	run := func(expected ...string) {
+		t.Helper()
+		ran = nil
+		r.Event(Event{Text: Put})
+		if !reflect.DeepEqual(ran, expected) {
+			t.Fatalf("expected %v to run, got %v", expected, ran)
+		}
+	}
+	run("a", "b", "c", "stop")
+
+	if !c.Remove() {
+		t.Fatal("expected c to be removed")
+	}
+	if c.Remove() {
+		t.Fatal("expected c to be removed only once")
+	}
+	if !r.Remove("stop") {
+		t.Fatal("expected stop to be removed")
+	}
+	run("a", "b", "d")
+
+	// registering a name again replaces the hook
+	r.Handle(Route{Name: "a", Priority: 4}, hook("A", true))
+	run("b", "d", "A")
+}
+
+func TestRouteMatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		route    Route
+		event    Event
+		expected bool
+	}{
+		{"empty", Route{}, Event{Text: Put}, true},
+		{"text", Route{Text: Put}, Event{Text: Put}, true},
+		{"other text", Route{Text: Put}, Event{Text: Get}, false},
+		{"key", Route{Key: '\t'}, Event{Origin: Keyboard, Action: BodyInsert, Text: "\t"}, true},
+		{"other key", Route{Key: '\t'}, Event{Origin: Keyboard, Action: BodyInsert, Text: "\n"}, false},
+		{"not typed", Route{Key: '\t'}, Event{Origin: Mouse, Action: BodyInsert, Text: "\t"}, false},
+		{"origin", Route{Origin: Mouse}, Event{Origin: Keyboard}, false},
+		{"action", Route{Action: B2Tag}, Event{Action: B2Tag}, true},
+		{"extension", Route{Extensions: []string{".go"}}, Event{File: "/tmp/a.go"}, true},
+		{"other extension", Route{Extensions: []string{".go"}}, Event{File: "/tmp/a.c"}, false},
+	}
+	for _, tc := range testCases {
+		if got := tc.route.match(tc.event); got != tc.expected {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestBufRouter(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/router.txt", "")
+
+	b := NewBuf(fw.ID(), "/tmp/router.txt")
+	b.Router = NewRouter()
+	seen := make(chan string, 2)
+	for _, name := range []string{"one", "two"} {
+		name := name
+		b.Router.Handle(Route{Name: name, Key: '\n'}, func(e Event) (Event, bool) {
+			seen <- name
+			return e, true
+		})
+	}
+	go b.Start()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Type("\n")
+	for _, expected := range []string{"one", "two"} {
+		select {
+		case name := <-seen:
+			if name != expected {
+				t.Fatalf("expected %s to run, got %s", expected, name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s was not run", expected)
+		}
+	}
+	waitFor(t, "typed text", func() bool {
+		return fw.Body() == "\n"
+	})
+}
@@ -0,0 +1,23 @@
+package nyne
+
+import "unicode/utf8"
+
+// RuneOffset returns the number of runes in text before the byte
+// offset off. Acme addresses count runes while Go indexes text by
+// byte.
+func RuneOffset(text []byte, off int) int {
+	if off > len(text) {
+		off = len(text)
+	}
+	return utf8.RuneCount(text[:off])
+}
+
+// ByteOffset returns the byte offset of the rune offset q in text
+func ByteOffset(text []byte, q int) int {
+	off := 0
+	for ; q > 0 && off < len(text); q-- {
+		_, n := utf8.DecodeRune(text[off:])
+		off += n
+	}
+	return off
+}
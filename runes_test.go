@@ -0,0 +1,24 @@
+package nyne
+
+import "testing"
+
+func TestOffsets(t *testing.T) {
+	text := []byte("aé😀b")
+	testCases := []struct {
+		rune, byte int
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 3},
+		{3, 7},
+		{4, 8},
+	}
+	for _, tc := range testCases {
+		if q := RuneOffset(text, tc.byte); q != tc.rune {
+			t.Fatalf("expected byte %d to be rune %d, got %d", tc.byte, tc.rune, q)
+		}
+		if off := ByteOffset(text, tc.rune); off != tc.byte {
+			t.Fatalf("expected rune %d to be byte %d, got %d", tc.rune, tc.byte, off)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package nyne
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Shadow is a copy of a window body kept in memory. Buf keeps it in
+// sync from the body's insert and delete events so that hooks can
+// read the body without reading the window.
+//
+// The text is held in a piece table: the original body is never
+// modified and inserted text is appended to a second buffer, so that
+// edits only split the list of pieces instead of copying the body.
+type Shadow struct {
+	mu     sync.RWMutex
+	orig   []rune
+	add    []rune
+	pieces []piece
+	n      int
+}
+
+// piece is a run of text from either the original or the added runes
+type piece struct {
+	add        bool
+	start, len int
+}
+
+// NewShadow constructs a Shadow of the body
+func NewShadow(body []byte) *Shadow {
+	s := &Shadow{}
+	s.Reset(body)
+	return s
+}
+
+// Reset replaces the text of the Shadow with body
+func (s *Shadow) Reset(body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orig = []rune(string(body))
+	s.add = nil
+	s.pieces = nil
+	if len(s.orig) > 0 {
+		s.pieces = []piece{{start: 0, len: len(s.orig)}}
+	}
+	s.n = len(s.orig)
+}
+
+// Len returns the number of runes in the text
+func (s *Shadow) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.n
+}
+
+// Insert inserts text at the rune offset q
+func (s *Shadow) Insert(q int, text []rune) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q < 0 || q > s.n {
+		return fmt.Errorf("insert at #%d out of range", q)
+	}
+	if len(text) == 0 {
+		return nil
+	}
+	p := piece{add: true, start: len(s.add), len: len(text)}
+	s.add = append(s.add, text...)
+	s.n += len(text)
+
+	i := s.split(q)
+	// typing appends to the piece that was last inserted
+	if i > 0 {
+		prev := &s.pieces[i-1]
+		if prev.add && prev.start+prev.len == p.start {
+			prev.len += p.len
+			return nil
+		}
+	}
+	s.pieces = append(s.pieces, piece{})
+	copy(s.pieces[i+1:], s.pieces[i:])
+	s.pieces[i] = p
+	return nil
+}
+
+// Delete removes the runes between q0 and q1
+func (s *Shadow) Delete(q0, q1 int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q0 < 0 || q1 < q0 || q1 > s.n {
+		return fmt.Errorf("delete of #%d,#%d out of range", q0, q1)
+	}
+	i := s.split(q0)
+	j := s.split(q1)
+	s.pieces = append(s.pieces[:i], s.pieces[j:]...)
+	s.n -= q1 - q0
+	return nil
+}
+
+// Runes returns the text
+func (s *Shadow) Runes() []rune {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := make([]rune, 0, s.n)
+	for _, p := range s.pieces {
+		r = append(r, s.text(p)...)
+	}
+	return r
+}
+
+// Bytes returns the text encoded as UTF-8
+func (s *Shadow) Bytes() []byte {
+	return []byte(string(s.Runes()))
+}
+
+func (s *Shadow) text(p piece) []rune {
+	if p.add {
+		return s.add[p.start : p.start+p.len]
+	}
+	return s.orig[p.start : p.start+p.len]
+}
+
+// split returns the index of the piece that starts at q, splitting the
+// piece that q falls within if needed
+func (s *Shadow) split(q int) int {
+	off := 0
+	for i, p := range s.pieces {
+		if off == q {
+			return i
+		}
+		if q < off+p.len {
+			k := q - off
+			s.pieces = append(s.pieces, piece{})
+			copy(s.pieces[i+1:], s.pieces[i:])
+			s.pieces[i] = piece{add: p.add, start: p.start, len: k}
+			s.pieces[i+1] = piece{add: p.add, start: p.start + k, len: p.len - k}
+			return i + 1
+		}
+		off += p.len
+	}
+	return len(s.pieces)
+}
@@ -0,0 +1,45 @@
+package nyne
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestShadow(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	expected := []rune("héllo\nwörld\n")
+	s := NewShadow([]byte(string(expected)))
+	for i := 0; i < 1000; i++ {
+		q0 := r.Intn(len(expected) + 1)
+		if r.Intn(2) == 0 {
+			text := []rune("añ\n")[:r.Intn(4)]
+			if err := s.Insert(q0, text); err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected[:q0], append(append([]rune(nil), text...), expected[q0:]...)...)
+		} else {
+			q1 := q0 + r.Intn(len(expected)-q0+1)
+			if err := s.Delete(q0, q1); err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected[:q0], expected[q1:]...)
+		}
+		if string(s.Runes()) != string(expected) || s.Len() != len(expected) {
+			t.Fatalf("edit %d: expected %q, got %q", i, string(expected), string(s.Runes()))
+		}
+	}
+}
+
+func TestShadowOutOfRange(t *testing.T) {
+	s := NewShadow([]byte("abc"))
+	if err := s.Insert(4, []rune("x")); err == nil {
+		t.Fatal("expected insert past the end to fail")
+	}
+	if err := s.Delete(2, 4); err == nil {
+		t.Fatal("expected delete past the end to fail")
+	}
+	s.Reset([]byte("xyz"))
+	if string(s.Bytes()) != "xyz" {
+		t.Fatalf("expected %q, got %q", "xyz", s.Bytes())
+	}
+}
@@ -0,0 +1,214 @@
+package nyne
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tag is a window tag split into the sections acme and the user
+// maintain. Acme writes the file name and its builtin commands before
+// the first vertical bar, and everything after the bar is left to the
+// user.
+type Tag struct {
+	File     string
+	Builtins []string
+	User     string
+}
+
+// ParseTag parses the contents of a tag file
+func ParseTag(tag []byte) Tag {
+	s := string(tag)
+	var t Tag
+	before := s
+	if i := strings.IndexByte(s, '|'); i >= 0 {
+		before, t.User = s[:i], s[i+1:]
+	}
+	before = strings.TrimSpace(before)
+	if strings.HasPrefix(before, "'") {
+		// acme quotes names containing spaces
+		if i := strings.IndexByte(before[1:], '\''); i >= 0 {
+			t.File = before[1 : i+1]
+			before = before[i+2:]
+		}
+	} else if f := strings.Fields(before); len(f) > 0 {
+		t.File = f[0]
+		before = before[len(f[0]):]
+	}
+	t.Builtins = strings.Fields(before)
+	return t
+}
+
+// String returns the tag as acme would display it
+func (t Tag) String() string {
+	var b strings.Builder
+	if strings.ContainsRune(t.File, ' ') {
+		b.WriteString("'" + t.File + "'")
+	} else {
+		b.WriteString(t.File)
+	}
+	for _, w := range t.Builtins {
+		b.WriteString(" " + w)
+	}
+	b.WriteString(" |")
+	b.WriteString(t.User)
+	return b.String()
+}
+
+// Has reports whether the command is in either section of the tag
+func (t Tag) Has(cmd string) bool {
+	for _, w := range t.Builtins {
+		if w == cmd {
+			return true
+		}
+	}
+	for _, w := range strings.Fields(t.User) {
+		if w == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// WithMenu returns the tag with the menu text in the user section.
+// The tag is left alone if it already has the menu and no other copies
+// of its words. Otherwise the menu words are taken out and the menu is
+// put where the first of them was, or at the end of the user section
+// if there were none, so the menu is never duplicated and the user's
+// own words keep their order.
+func (t Tag) WithMenu(menu string) Tag {
+	words := strings.Fields(menu)
+	if i := strings.Index(t.User, menu); i >= 0 {
+		before, after := t.User[:i], t.User[i+len(menu):]
+		if _, n := removeWords(before, words); n < 0 {
+			if _, n := removeWords(after, words); n < 0 {
+				return t
+			}
+		}
+	}
+	rest, p := removeWords(t.User, words)
+	if strings.TrimSpace(rest) == "" {
+		t.User = menu
+		return t
+	}
+	if p < 0 {
+		p = len(rest)
+	}
+	before, after := rest[:p], rest[p:]
+	if before != "" && !startsSpace(menu) && !endsSpace(before) {
+		before += " "
+	}
+	if after != "" && !endsSpace(menu) && !startsSpace(after) {
+		after = " " + after
+	}
+	t.User = before + menu + after
+	return t
+}
+
+func startsSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func endsSpace(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+// WithCommands returns the tag with the commands that are missing
+// from it appended to the user section
+func (t Tag) WithCommands(cmds ...string) Tag {
+	for _, cmd := range cmds {
+		if cmd == "" || t.Has(cmd) {
+			continue
+		}
+		t.User += " " + cmd
+	}
+	return t
+}
+
+// removeWords removes the words from text along with the space
+// before each of them. It returns where the first of them was in the
+// text that is left, or -1 if none were found.
+func removeWords(text string, words []string) (string, int) {
+	remove := make(map[string]bool)
+	for _, w := range words {
+		remove[w] = true
+	}
+	var b strings.Builder
+	first := -1
+	r := []rune(text)
+	space := 0
+	for i := 0; i < len(r); {
+		if unicode.IsSpace(r[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(r) && !unicode.IsSpace(r[j]) {
+			j++
+		}
+		if !remove[string(r[i:j])] {
+			b.WriteString(string(r[space:j]))
+		} else if first < 0 {
+			first = b.Len()
+		}
+		space = j
+		i = j
+	}
+	b.WriteString(string(r[space:]))
+	return b.String(), first
+}
+
+// ReadTag reads and parses the tag of the window
+func ReadTag(w Window) (Tag, error) {
+	tag, err := w.Tag()
+	if err != nil {
+		return Tag{}, err
+	}
+	return ParseTag(tag), nil
+}
+
+// WriteTag replaces the user section of the window's tag with the
+// tag's. Acme maintains the rest of the tag itself.
+func WriteTag(w Window, t Tag) error {
+	if err := w.ClearTag(); err != nil {
+		return err
+	}
+	if t.User == "" {
+		return nil
+	}
+	return w.AppendTag(t.User)
+}
+
+// SetMenu puts the menu in the user section of the window's tag as
+// WithMenu does. The tag is left alone if it already has the menu, and
+// a menu that goes at the end is appended without rewriting the rest.
+func SetMenu(w Window, menu []string) error {
+	t, err := ReadTag(w)
+	if err != nil {
+		return err
+	}
+	nt := t.WithMenu(strings.Join(menu, ""))
+	if nt.User == t.User {
+		return nil
+	}
+	if strings.HasPrefix(nt.User, t.User) {
+		return w.AppendTag(nt.User[len(t.User):])
+	}
+	return WriteTag(w, nt)
+}
+
+// EnsureCommands appends the commands missing from the window's tag
+// to it
+func EnsureCommands(w Window, cmds ...string) error {
+	t, err := ReadTag(w)
+	if err != nil {
+		return err
+	}
+	nt := t.WithCommands(cmds...)
+	if nt.User == t.User {
+		return nil
+	}
+	return w.AppendTag(nt.User[len(t.User):])
+}
@@ -0,0 +1,95 @@
+package nyne
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	testCases := []struct {
+		tag      string
+		expected Tag
+	}{
+		{
+			"/tmp/a.go Del Snarf Undo | Look |fmt",
+			Tag{"/tmp/a.go", []string{"Del", "Snarf", "Undo"}, " Look |fmt"},
+		},
+		{
+			"'/tmp/a b.go' Del Snarf |",
+			Tag{"/tmp/a b.go", []string{"Del", "Snarf"}, ""},
+		},
+		{"/tmp/ Del", Tag{"/tmp/", []string{"Del"}, ""}},
+	}
+	for _, tc := range testCases {
+		got := ParseTag([]byte(tc.tag))
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Fatalf("expected %#v, got %#v", tc.expected, got)
+		}
+	}
+	tag := "/tmp/a.go Del Snarf | Look"
+	if got := ParseTag([]byte(tag)).String(); got != tag {
+		t.Fatalf("expected %q, got %q", tag, got)
+	}
+}
+
+func TestTagWithMenu(t *testing.T) {
+	menu := " Put  Undo\n|com  Ldef"
+	testCases := []struct {
+		user, expected string
+	}{
+		{"", menu},
+		{menu, menu},
+		{menu + " mine", menu + " mine"},
+		{" mine", " mine" + menu},
+		{" mine Undo", " mine" + menu},
+		{" Put Undo mine |com", menu + " mine"},
+		{" first Undo mine Put", " first" + menu + " mine"},
+		{menu + " Put  Undo\n|com  Ldef", menu},
+		{"mine", "mine" + menu},
+	}
+	for _, tc := range testCases {
+		got := Tag{User: tc.user}.WithMenu(menu)
+		if got.User != tc.expected {
+			t.Fatalf("%q: expected %q, got %q", tc.user, tc.expected, got.User)
+		}
+		if again := got.WithMenu(menu); again.User != got.User {
+			t.Fatalf("%q: menu is not idempotent, got %q", tc.user, again.User)
+		}
+	}
+}
+
+func TestTagWithCommands(t *testing.T) {
+	tag := Tag{Builtins: []string{"Del", "Snarf"}, User: " Look"}
+	got := tag.WithCommands("Del", "Look", "Get", "Get")
+	if got.User != " Look Get" {
+		t.Fatalf("expected %q, got %q", " Look Get", got.User)
+	}
+}
+
+func TestSetMenu(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/menu.txt", "")
+	w, err := OpenWin(fw.ID(), "/tmp/menu.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.AppendTag(" mine"); err != nil {
+		t.Fatal(err)
+	}
+	menu := []string{" Put  ", "Undo  ", "\n", "|com"}
+	for i := 0; i < 2; i++ {
+		if err := SetMenu(w, menu); err != nil {
+			t.Fatal(err)
+		}
+		if err := EnsureCommands(w, "Snarf", "Look", "Get"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := " Look  mine Put  Undo  \n|com Get"
+	if tag := fw.Tag(); !strings.HasSuffix(tag, " |"+expected) {
+		t.Fatalf("expected tag to end in %q, got %q", expected, tag)
+	}
+}
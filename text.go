@@ -1,12 +1,11 @@
 package nyne
 
 import (
-	"log"
 	"unicode/utf8"
 )
 
 // WinFunc retrieves the Win by its ID
-type WinFunc func(int) (*Win, error)
+type WinFunc func(int) (Window, error)
 
 // TabwidthFunc returns the tabwidth based on properties of the Event
 type TabwidthFunc func(Event) int
@@ -24,8 +23,8 @@ func Tab(width int, expand bool) []byte {
 	return []byte{0x09}
 }
 
-// Tabexpand expands tabs to spaces
-func Tabexpand(condition Condition, win WinFunc, tabwidth TabwidthFunc) (rune, Handler) {
+// Tabexpand expands tabs to spaces. Errors are passed to report.
+func Tabexpand(condition Condition, win WinFunc, tabwidth TabwidthFunc, report ErrorHandler) (rune, Handler) {
 	return '\t', func(e Event) (Event, bool) {
 		ok := true
 		if !condition(e) {
@@ -34,22 +33,27 @@ func Tabexpand(condition Condition, win WinFunc, tabwidth TabwidthFunc) (rune, H
 
 		w, err := win(e.ID)
 		if err != nil {
-			log.Println(err)
+			report(&HookError{ID: e.ID, File: e.File, Hook: "Tabexpand", Err: err})
 			return e, ok
 		}
 
 		tab := Tab(tabwidth(e), true)
 
-		// select current character
-		err = w.SetAddr("#%d;+#1", e.SelBegin)
+		err = Transaction(w, func(w Window) error {
+			// select current character
+			err := w.SetAddr("#%d;+#1", e.SelBegin)
+			if err != nil {
+				return err
+			}
+
+			// replace character with tab
+			return w.SetData(tab)
+		})
 		if err != nil {
-			log.Println(err)
+			report(&HookError{ID: e.ID, File: e.File, Hook: "Tabexpand", Err: err})
 			w.WriteEvent(e)
 		}
 
-		// replace character with tab
-		w.SetData(tab)
-
 		// update the event to reflect the change
 		rc := utf8.RuneCount(tab)
 		selEnd := e.SelBegin + rc
@@ -0,0 +1,155 @@
+package nyne
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceName is the name of the window a Formatter traces its hooks to
+// in debug mode
+const TraceName = "+nyne/trace"
+
+// Trace is an event read by a Buf or the result of a hook run on it
+type Trace struct {
+	Time  time.Time
+	Event Event
+	// Hook is the name of the hook that ran, or empty for the event
+	// as it was read
+	Hook string
+	// Stop is set when the hook stopped the chain of hooks
+	Stop bool
+	// Err is the error returned by a write hook
+	Err  error
+	Took time.Duration
+}
+
+// String formats the trace as a line. Events are printed with their
+// window, file, origin, action, flag, selection and text, and hooks
+// indented below them with what they returned and how long they took.
+func (t Trace) String() string {
+	e := t.Event
+	if t.Hook == "" {
+		return fmt.Sprintf("%s %d %s %s%s %d #%d,#%d %q",
+			t.Time.Format("15:04:05.000"), e.ID, e.File,
+			runeText(e.Origin.Rune()), runeText(e.Action.Rune()),
+			e.Flag, e.SelBegin, e.SelEnd, e.Text)
+	}
+	result := "ok"
+	switch {
+	case t.Err != nil:
+		result = "error: " + t.Err.Error()
+	case t.Stop:
+		result = "stop"
+	}
+	return fmt.Sprintf("\t%s %s: %s %v", e.Text, t.Hook, result, t.Took)
+}
+
+// Tracer receives the traces of a Buf. It is called from the goroutines
+// of every Buf it is given to.
+type Tracer func(Trace)
+
+// TraceTo returns a Tracer that writes each trace to w as a line.
+// Write errors are ignored, so that a trace window that has been
+// deleted does not stop the hooks.
+func TraceTo(w io.Writer) Tracer {
+	var mu sync.Mutex
+	return func(t Trace) {
+		line := strings.Replace(t.String(), "\n", `\n`, -1) + "\n"
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, line)
+	}
+}
+
+// traceWin is the window a Formatter traces to. It is opened each time
+// the Formatter connects to acme, as the window of an acme that has
+// exited can no longer be written to.
+type traceWin struct {
+	mu sync.Mutex
+	o  *Output
+}
+
+// open opens the trace window of the acme just connected to, closing
+// the one opened before
+func (tw *traceWin) open() error {
+	o, err := OutputWin(TraceName, OutputOptions{Mode: OutputAppend})
+	if err != nil {
+		return err
+	}
+	tw.mu.Lock()
+	old := tw.o
+	tw.o = o
+	tw.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Write writes to the trace window opened last
+func (tw *traceWin) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.o == nil {
+		return 0, errors.New("no trace window")
+	}
+	return tw.o.Write(p)
+}
+
+// Close closes the trace window
+func (tw *traceWin) Close() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.o != nil {
+		tw.o.Close()
+		tw.o = nil
+	}
+}
+
+// trace passes the trace to the Tracer if there is one
+func (tr Tracer) trace(t Trace) {
+	if tr == nil {
+		return
+	}
+	if t.Time.IsZero() {
+		t.Time = time.Now()
+	}
+	tr(t)
+}
+
+// event runs the Handler and traces what it returned
+func (tr Tracer) event(name string, h Handler, e Event) (Event, bool) {
+	start := time.Now()
+	out, ok := h(e)
+	tr.trace(Trace{Event: e, Hook: name, Stop: !ok, Took: time.Since(start)})
+	// name the write hooks it added after it
+	n := len(out.writeNames)
+	for len(out.writeNames) < len(out.WriteHooks) {
+		out.writeNames = append(out.writeNames[:n:n], name)
+		n++
+	}
+	return out, ok
+}
+
+// win runs the WinHandler and traces how long it took
+func (tr Tracer) win(name string, h WinHandler, w Window, e Event) {
+	if tr == nil {
+		h(w)
+		return
+	}
+	start := time.Now()
+	h(w)
+	tr.trace(Trace{Event: e, Hook: name, Took: time.Since(start)})
+}
+
+// writeName returns the name of the i'th write hook of the event
+func writeName(e Event, i int) string {
+	if i < len(e.writeNames) {
+		return e.writeNames[i] + " write"
+	}
+	return fmt.Sprintf("write %d", i)
+}
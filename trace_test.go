@@ -0,0 +1,126 @@
+package nyne
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTraceString(t *testing.T) {
+	at := time.Date(2021, 1, 2, 15, 4, 5, 6e6, time.UTC)
+	e := Event{
+		ID:       3,
+		File:     "/tmp/a.go",
+		Origin:   Keyboard,
+		Action:   BodyInsert,
+		Text:     "\t",
+		SelBegin: 4,
+		SelEnd:   5,
+	}
+	testCases := []struct {
+		trace    Trace
+		expected string
+	}{
+		{Trace{Time: at, Event: e}, `15:04:05.006 3 /tmp/a.go KI 0 #4,#5 "\t"`},
+		{Trace{Time: at, Event: Event{ID: 3, File: "/tmp/a.go", Text: New}}, `15:04:05.006 3 /tmp/a.go  0 #0,#0 "New"`},
+		{Trace{Event: e, Hook: "tab", Took: time.Millisecond}, "\t\t tab: ok 1ms"},
+		{Trace{Event: e, Hook: "tab", Stop: true}, "\t\t tab: stop 0s"},
+		{Trace{Event: e, Hook: "tab", Err: errors.New("failed")}, "\t\t tab: error: failed 0s"},
+	}
+	for _, tc := range testCases {
+		if s := tc.trace.String(); s != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, s)
+		}
+	}
+}
+
+func TestBufTrace(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/trace.txt", "")
+
+	b := NewBuf(fw.ID(), "/tmp/trace.txt")
+	b.KeyHooks['\t'] = func(e Event) (Event, bool) {
+		return e, false
+	}
+	b.Router = NewRouter()
+	b.Router.Handle(Route{Name: "newline", Key: '\n'}, func(e Event) (Event, bool) {
+		e.WriteHooks = append(e.WriteHooks, func(Event) error {
+			return errors.New("failed")
+		})
+		return e, true
+	})
+	var mu sync.Mutex
+	var traces []string
+	b.Trace = func(tr Trace) {
+		mu.Lock()
+		defer mu.Unlock()
+		if tr.Hook == "" {
+			traces = append(traces, string(tr.Event.Text))
+			return
+		}
+		result := "ok"
+		if tr.Stop {
+			result = "stop"
+		}
+		if tr.Err != nil {
+			result = tr.Err.Error()
+		}
+		traces = append(traces, tr.Hook+" "+result)
+	}
+	go b.Start()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Type("\t")
+	fw.Type("\n")
+	expected := []string{
+		"New",
+		"\t",
+		`key '\t' stop`,
+		"\n",
+		"newline ok",
+		"newline write failed",
+	}
+	waitFor(t, "traces", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(traces) >= len(expected)
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(traces, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected traces %q, got %q", expected, traces)
+	}
+}
+
+func TestFormatterTrace(t *testing.T) {
+	defer fsrv.Reset()
+	f, err := NewFormatter(nil, []string{"fmt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.debug = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.RunContext(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, "the trace window", func() bool {
+		return fsrv.Lookup(TraceName) != nil
+	})
+
+	fw := fsrv.NewWindow("/tmp/trace.txt", "")
+	tw := fsrv.Lookup(TraceName)
+	waitFor(t, "the menu hook to be traced", func() bool {
+		return strings.Contains(tw.Body(), "New nyne/menu: ok")
+	})
+	if !strings.Contains(tw.Body(), " /tmp/trace.txt ") {
+		t.Fatalf("expected the event of window %d to be traced, got %q", fw.ID(), tw.Body())
+	}
+}
@@ -8,64 +8,102 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 
 	"strings"
+	"sync"
+	"unicode"
 	"unicode/utf8"
 
 	"9fans.net/go/acme"
 	"9fans.net/go/draw"
-	p9client "9fans.net/go/plan9/client"
+	"9fans.net/go/plan9"
+	"9fans.net/go/plan9/client"
+	"github.com/dnjp/nyne/addr"
 )
 
 // Win represents the active Acme window
 type Win struct {
-	ID        int
-	File      string
-	Lastpoint int
-	w         *acme.Win
+	id     int
+	file   string
+	c      *conn
+	mu     sync.Mutex
+	fids   map[string]*client.Fid
+	ev     *conn
+	events *eventReader
+	limit  *Range
+	tab    int
+	edit   sync.Mutex
+	reads  sync.Mutex
 }
 
 // NewWin constructs a Win object from acme window
 func NewWin() (*Win, error) {
-	w, err := acme.New()
+	c, err := mount()
 	if err != nil {
 		return nil, err
 	}
-	return &Win{w: w}, nil
+	ctl, err := c.open("new/ctl", plan9.ORDWR)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 100)
+	n, err := ctl.Read(buf)
+	if err != nil {
+		ctl.Close()
+		return nil, err
+	}
+	a := strings.Fields(string(buf[:n]))
+	if len(a) == 0 {
+		ctl.Close()
+		return nil, errors.New("short read from acme/new/ctl")
+	}
+	id, err := strconv.Atoi(a[0])
+	if err != nil {
+		ctl.Close()
+		return nil, fmt.Errorf("invalid window id in acme/new/ctl: %s", a[0])
+	}
+	return newWin(c, id, "", ctl), nil
 }
 
 // OpenWin opens an acme window
 func OpenWin(id int, file string) (*Win, error) {
-	w, err := acme.Open(id, nil)
+	c, err := mount()
 	if err != nil {
 		return nil, err
 	}
+	ctl, err := c.open(fmt.Sprintf("%d/ctl", id), plan9.ORDWR)
+	if err != nil {
+		return nil, err
+	}
+	return newWin(c, id, file, ctl), nil
+}
+
+func newWin(c *conn, id int, file string, ctl *client.Fid) *Win {
 	return &Win{
-		ID:   id,
-		File: file,
-		w:    w,
-	}, nil
+		id:   id,
+		file: file,
+		c:    c,
+		fids: map[string]*client.Fid{"ctl": ctl},
+	}
 }
 
 // Windows returns all open acme windows
 func Windows() (map[int]*Win, error) {
-	ws, err := acme.Windows()
+	ws, err := windows()
 	if err != nil {
 		return nil, err
 	}
 	wins := make(map[int]*Win)
-	for _, wi := range ws {
-		w, err := acme.Open(wi.ID, nil)
+	for id, name := range ws {
+		w, err := OpenWin(id, name)
 		if err != nil {
 			return nil, err
 		}
-		wins[wi.ID] = &Win{
-			ID:   wi.ID,
-			File: wi.Name,
-			w:    w,
-		}
+		wins[id] = w
 	}
 	return wins, nil
 }
@@ -93,27 +131,50 @@ func FocusedWinID(addr string) (int, error) {
 
 // FocusedWinAddr returns the address of the active window using acmefocused
 func FocusedWinAddr() string {
-	return filepath.Join(p9client.Namespace(), "acmefocused")
+	return filepath.Join(client.Namespace(), "acmefocused")
+}
+
+// ID returns the acme window ID
+func (w *Win) ID() int {
+	return w.id
 }
 
-// EventChan opens a channel to acme events
-func (w *Win) EventChan(id int, filename string, stop <-chan struct{}) (<-chan Event, <-chan error) {
-	errs := make(chan error)
+// File returns the name of the file in the window
+func (w *Win) File() string {
+	return w.file
+}
+
+// EventChan opens a channel to acme events. The events channel is
+// closed once the event file can no longer be read.
+//
+// The event file is read over its own connection so that Close can
+// interrupt a pending read.
+func (w *Win) EventChan(stop <-chan struct{}) (<-chan Event, <-chan error) {
+	errs := make(chan error, 1)
 	events := make(chan Event)
+	r, err := w.openEvents()
+	if err != nil {
+		errs <- err
+		return events, errs
+	}
 	go func() {
-		ec := w.w.EventChan()
+		defer close(events)
 		for {
-			select {
-			case e, ok := <-ec:
-				if !ok {
-					return
-				}
-				event, err := NewEvent(e, id, filename)
-				if err != nil {
-					errs <- err
+			e, err := r.Read()
+			if err != nil {
+				return
+			}
+			event, err := NewEvent(e, w.id, w.file)
+			if err != nil {
+				select {
+				case errs <- err:
 					continue
+				case <-stop:
+					return
 				}
-				events <- event
+			}
+			select {
+			case events <- event:
 			case <-stop:
 				return
 			}
@@ -122,80 +183,257 @@ func (w *Win) EventChan(id int, filename string, stop <-chan struct{}) (<-chan E
 	return events, errs
 }
 
+func (w *Win) openEvents() (*eventReader, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.events != nil {
+		return nil, fmt.Errorf("event file already open")
+	}
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	r, err := openEvents(c, w.id)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	w.ev = c
+	w.events = r
+	return r, nil
+}
+
 // WriteEvent writes the acme event to the log
 func (w *Win) WriteEvent(e Event) error {
 	raw, err := e.Log()
 	if err != nil {
 		return err
 	}
-	return w.w.WriteEvent(raw)
+	return w.writeEvent(raw)
+}
+
+// writeEvent writes the event to the event file opened by EventChan.
+// Acme only accepts events written back to the file they were read
+// from.
+func (w *Win) writeEvent(e *acme.Event) error {
+	w.mu.Lock()
+	r := w.events
+	w.mu.Unlock()
+	if r != nil {
+		return r.Write(e)
+	}
+	// holding the event file open would take the window's events
+	// away from acme, so it is only open for the write
+	f, err := w.c.open(fmt.Sprintf("%d/event", w.id), plan9.OWRITE)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%c%c%d %d \n", e.C1, e.C2, e.Q0, e.Q1)
+	_, err = f.Write(buf.Bytes())
+	return err
 }
 
 // Name sets the name for the win
 func (w *Win) Name(format string, args ...interface{}) error {
-	return w.w.Name(format, args...)
+	name := fmt.Sprintf(format, args...)
+	if err := w.Ctl("name %s", name); err != nil {
+		return err
+	}
+	w.file = name
+	return nil
+}
+
+// Ctl writes the formatted message to the window's ctl file
+func (w *Win) Ctl(format string, args ...interface{}) error {
+	return w.write("ctl", []byte(fmt.Sprintf(format, args...)))
+}
+
+// Errorf writes the formatted message to the +Errors window of the
+// window's directory
+func (w *Win) Errorf(format string, args ...interface{}) error {
+	return w.write("errors", []byte(fmt.Sprintf(format, args...)))
 }
 
 // Close closes down the window with associated files
 func (w *Win) Close() {
-	w.w.CloseFiles()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ev != nil {
+		w.ev.Close()
+		w.ev = nil
+		w.events = nil
+	}
+	for name, f := range w.fids {
+		f.Close()
+		delete(w.fids, name)
+	}
+}
+
+// ErrNotRun is returned by Exec when acme did not run the command
+var ErrNotRun = errors.New("acme did not run the command")
+
+// ctlcmds are the builtin commands that have an equivalent control
+// message, which acme runs without the command being in the window
+var ctlcmds = map[string]string{
+	"Get":    "get",
+	"Put":    "put",
+	"Del":    "del",
+	"Delete": "delete",
 }
 
-// Exec executes the given command in the window tag
+// builtins are the commands acme runs itself. Any other command is
+// run as a program.
+var builtins = map[string]bool{
+	"Cut": true, "Del": true, "Delcol": true, "Delete": true,
+	"Dump": true, "Edit": true, "Exit": true, "Font": true,
+	"Get": true, "ID": true, "Incl": true, "Indent": true,
+	"Kill": true, "Load": true, "Local": true, "Look": true,
+	"New": true, "Newcol": true, "Paste": true, "Put": true,
+	"Putall": true, "Redo": true, "Send": true, "Snarf": true,
+	"Sort": true, "Tab": true, "Undo": true, "Zerox": true,
+}
+
+// Exec executes the command in the window as if it had been middle
+// clicked in the tag, without going through the tag where it can:
+//
+// Builtins with a control message are written to the ctl file.
+// External commands are run by Exec itself as acme runs them, with
+// $winid and $% set and in the directory of the window's file, and
+// their output is written to the window's +Errors. Exec waits for
+// them and returns the error they exited with, or ErrNotRun if they
+// could not be started.
+//
+// Other builtins can only be run by acme, which only executes text
+// that is in the window. The builtin is executed where it is if it is
+// in the tag already, and otherwise it is added to the end of the tag
+// for as long as it takes acme to run it. The tag file can only be
+// appended to or cleared, so taking the command out again rewrites
+// the user's section of the tag. That is only done if the section is
+// still as Exec left it, but text typed in the moment between reading
+// the tag and rewriting it is lost. Acme runs builtins before replying
+// to the event, so Exec returns ErrNotRun if acme refused the event.
 func (w *Win) Exec(exec string, args ...string) error {
-	if w == nil || w.w == nil {
+	if w == nil || w.c == nil {
 		return fmt.Errorf("window handle lost")
 	}
+	cmd := strings.TrimSpace(exec + " " + strings.Join(args, " "))
+	if cmd == "" {
+		return fmt.Errorf("no command")
+	}
+	if msg, ok := ctlcmds[cmd]; ok {
+		return w.Ctl(msg)
+	}
+	if !builtins[strings.Fields(cmd)[0]] {
+		return w.run(cmd)
+	}
 
 	tag, err := w.Tag()
 	if err != nil {
 		return fmt.Errorf("could not read tag: %w", err)
 	}
-
-	var before string
-	parts := strings.Split(string(tag), "|")
-	if len(parts) >= 2 {
-		before = parts[1]
+	q0, q1, ok := findCmd([]rune(string(tag)), cmd)
+	if !ok {
+		var added []byte
+		q0, q1, added, err = w.addCmd(tag, cmd)
+		if err != nil {
+			return err
+		}
+		defer w.removeCmd(ParseTag(tag), ParseTag(added))
 	}
 
-	cmd := fmt.Sprintf("%s %s", exec, strings.Join(args, " "))
-	if err := w.AppendTag(cmd); err != nil {
-		return fmt.Errorf("could not write tag: %w", err)
+	err = w.writeEvent(&acme.Event{C1: rune(Mouse), C2: rune(B2Tag), Q0: q0, Q1: q1})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotRun, err)
 	}
+	return nil
+}
 
-	rc := utf8.RuneCount(tag)
-	nr := utf8.RuneCountInString(cmd)
-	// TODO: this is broken when using 9fans 0.0.4 due to
-	// this commit:
-	// https://github.com/9fans/go/pull/2/commits/ec429baff6830331d0884359449b303d4a87e33c
-	evt := Event{
-		Origin:   Mouse,
-		Action:   B2Tag,
-		SelBegin: rc,
-		SelEnd:   rc + nr,
-		NumRunes: nr,
-		Text:     Text(cmd),
-		Flag:     HasChordedArg,
+// run runs an external command for the window the way acme does
+func (w *Win) run(cmd string) error {
+	tag, err := ReadTag(w)
+	if err != nil {
+		return fmt.Errorf("could not read tag: %w", err)
+	}
+	file := tag.File
+	if file == "" {
+		file = w.file
+	}
+	shell := "rc"
+	if _, err := exec.LookPath(shell); err != nil {
+		shell = "sh"
+	}
+	c := exec.Command(shell, "-c", cmd)
+	c.Env = append(os.Environ(),
+		"winid="+strconv.Itoa(w.id), "%="+file, "samfile="+file)
+	if filepath.IsAbs(file) {
+		c.Dir = filepath.Dir(file)
+		if strings.HasSuffix(file, "/") {
+			c.Dir = file
+		}
 	}
+	out, err := c.CombinedOutput()
+	if len(out) > 0 {
+		w.Errorf("%s", out)
+	}
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		return fmt.Errorf("%s: %w", cmd, err)
+	case err != nil:
+		return fmt.Errorf("%w: %v", ErrNotRun, err)
+	}
+	return nil
+}
 
-	log, err := evt.Log()
-	if err != nil {
-		return fmt.Errorf("could not convert event to log: %w", err)
+// addCmd appends the command to the tag and returns where it is along
+// with the tag it was added to
+func (w *Win) addCmd(tag []byte, cmd string) (q0, q1 int, added []byte, err error) {
+	text := cmd
+	if r, _ := utf8.DecodeLastRune(tag); !unicode.IsSpace(r) {
+		text = " " + cmd
+	}
+	if err := w.AppendTag(text); err != nil {
+		return 0, 0, nil, fmt.Errorf("could not write tag: %w", err)
 	}
-	err = w.w.WriteEvent(log)
+	q1 = utf8.RuneCount(tag) + utf8.RuneCountInString(text)
+	q0 = q1 - utf8.RuneCountInString(cmd)
+	added, err = w.Tag()
 	if err != nil {
-		return fmt.Errorf("could not write event: %w", err)
+		return 0, 0, nil, fmt.Errorf("could not read tag: %w", err)
 	}
-
-	if err = w.ClearTag(); err != nil {
-		return fmt.Errorf("could not clear tag: %w", err)
+	if r := []rune(string(added)); q1 > len(r) || string(r[q0:q1]) != cmd {
+		return 0, 0, nil, ErrNotRun
 	}
+	return q0, q1, added, nil
+}
 
-	if err := w.AppendTag(before); err != nil {
-		return fmt.Errorf("could not write tag: %w", err)
+// removeCmd restores the user's section of the tag to what it was
+// before addCmd, unless it has been edited since
+func (w *Win) removeCmd(before, added Tag) {
+	now, err := ReadTag(w)
+	if err != nil || now.User != added.User {
+		return
 	}
+	WriteTag(w, before)
+}
 
-	return nil
+// findCmd returns the range of the last occurrence of cmd in the tag
+// that is delimited by white space, as acme would expand it
+func findCmd(tag []rune, cmd string) (q0, q1 int, ok bool) {
+	c := []rune(cmd)
+	for q0 = len(tag) - len(c); q0 >= 0; q0-- {
+		q1 = q0 + len(c)
+		if string(tag[q0:q1]) != cmd {
+			continue
+		}
+		if (q0 == 0 || unicode.IsSpace(tag[q0-1])) &&
+			(q1 == len(tag) || unicode.IsSpace(tag[q1])) {
+			return q0, q1, true
+		}
+	}
+	return 0, 0, false
 }
 
 // Get is the equivalent to the Get interactive command with no
@@ -245,6 +483,30 @@ func (w *Win) DisableNoMark() error {
 	return w.write("ctl", []byte("mark"))
 }
 
+// Transaction runs fn with automatic marking turned off so that the
+// edits it makes can be undone with a single Undo. Marking is restored
+// when fn returns, even if it returns an error or panics. The window
+// is locked while fn runs.
+func (w *Win) Transaction(fn func(*Win) error) error {
+	w.Lock()
+	defer w.Unlock()
+	return transaction(w.DisableNoMark, w.NoMark, func() error {
+		return fn(w)
+	})
+}
+
+// Lock locks the window for a sequence of edits, such as setting addr
+// and writing data, so that the edits of another goroutine using the
+// Win are not made in between
+func (w *Win) Lock() {
+	w.edit.Lock()
+}
+
+// Unlock unlocks the window locked by Lock
+func (w *Win) Unlock() {
+	w.edit.Unlock()
+}
+
 // Clean marks the window clean as though it has just been written.
 func (w *Win) Clean() error {
 	return w.write("ctl", []byte("clean"))
@@ -257,10 +519,10 @@ func (w *Win) Dirty() error {
 
 // Tag returns the tag contents
 func (w *Win) Tag() ([]byte, error) {
-	if w == nil || w.w == nil {
+	if w == nil || w.c == nil {
 		return []byte{}, fmt.Errorf("window handle lost")
 	}
-	return w.w.ReadAll("tag")
+	return w.readAll("tag")
 }
 
 // ClearTag removes all text in the tag after the vertical bar.
@@ -270,18 +532,18 @@ func (w *Win) ClearTag() error {
 
 // AppendTag writes to the windows tag
 func (w *Win) AppendTag(text string) error {
-	if w == nil || w.w == nil {
+	if w == nil || w.c == nil {
 		return fmt.Errorf("window handle lost")
 	}
-	return w.w.Fprintf("tag", "%s", text)
+	return w.write("tag", []byte(text))
 }
 
 // Body returns the window body
 func (w *Win) Body() ([]byte, error) {
-	if w == nil || w.w == nil {
+	if w == nil || w.c == nil {
 		return []byte{}, fmt.Errorf("window handle lost")
 	}
-	return w.w.ReadAll("body")
+	return w.readAll("body")
 }
 
 // ClearBody clears the text from the body
@@ -297,34 +559,22 @@ func (w *Win) ClearBody() error {
 
 // AppendBody appends the given text to the body
 func (w *Win) AppendBody(data []byte) error {
-	if w == nil || w.w == nil {
+	if w == nil || w.c == nil {
 		return fmt.Errorf("window handle lost")
 	}
 	return w.write("body", data)
 }
 
 // Char reads the character at q0
-func (w *Win) Char(q0 int) (c byte, err error) {
-	var dat []byte
-	err = w.SetAddr("#%d;#%d", q0, q0+1)
+func (w *Win) Char(q0 int) (c rune, err error) {
+	r, err := w.RuneRange(q0, q0+1)
 	if err != nil {
 		if err.Error() == "address out of range" {
-			c = 0
-			err = io.EOF
-			return
+			return 0, io.EOF
 		}
-		return
-	}
-	dat, err = w.Data(q0, q0+1)
-	if err != nil {
-		return
+		return 0, err
 	}
-	if len(dat) == 0 {
-		err = fmt.Errorf("no data")
-		return
-	}
-	c = dat[0]
-	return
+	return r[0], nil
 }
 
 // SetAddr takes an addr which may be written with any textual address
@@ -334,17 +584,17 @@ func (w *Win) SetAddr(fmtstr string, args ...interface{}) error {
 	if len(args) > 0 {
 		addr = fmt.Sprintf(fmtstr, args...)
 	}
-	return w.w.Addr(addr)
+	return w.write("addr", []byte(addr))
 }
 
 // Addr returns the current address of the window
 //
 // Derived from https://github.com/fhs/acme-lsp/blob/623cb39c2e31bddda0ad7c216c2f3c2fcfcf237f/internal/acme/acme.go#L366
 func (w *Win) Addr() (q0, q1 int, err error) {
-	if w == nil || w.w == nil {
+	if w == nil || w.c == nil {
 		return 0, 0, fmt.Errorf("window handle lost")
 	}
-	buf, err := w.w.ReadAll("addr")
+	buf, err := w.readAll("addr")
 	if err != nil {
 		return 0, 0, err
 	}
@@ -388,7 +638,82 @@ func (w *Win) SelectionFromAddr() error {
 // LimitSearchToAddr restricts subsequent searches to the current addr
 // address.
 func (w *Win) LimitSearchToAddr() error {
-	return w.write("ctl", []byte("limit=addr"))
+	q0, q1, err := w.Addr()
+	if err != nil {
+		return err
+	}
+	if err := w.write("ctl", []byte("limit=addr")); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.limit = &Range{Q0: q0, Q1: q1}
+	w.mu.Unlock()
+	return nil
+}
+
+// Find returns the range of every match of the regular expression re
+// in the body, or in the addr given to LimitSearchToAddr
+func (w *Win) Find(re string) ([]Range, error) {
+	r, body, lim, err := w.search(re)
+	if err != nil {
+		return nil, err
+	}
+	return addr.All(r, body, lim), nil
+}
+
+// FindNext returns the first match of re starting at or after q,
+// wrapping around to the start of the body
+func (w *Win) FindNext(re string, q int) (Range, error) {
+	r, body, lim, err := w.search(re)
+	if err != nil {
+		return Range{}, err
+	}
+	m, ok := addr.Next(r, body, lim, q)
+	if !ok {
+		return Range{}, addr.ErrNoMatch
+	}
+	return m, nil
+}
+
+// FindPrev returns the last match of re ending at or before q,
+// wrapping around to the end of the body
+func (w *Win) FindPrev(re string, q int) (Range, error) {
+	r, body, lim, err := w.search(re)
+	if err != nil {
+		return Range{}, err
+	}
+	m, ok := addr.Prev(r, body, lim, q)
+	if !ok {
+		return Range{}, addr.ErrNoMatch
+	}
+	return m, nil
+}
+
+// search compiles re and reads the body along with the range searches
+// are limited to
+func (w *Win) search(re string) (*regexp.Regexp, []rune, Range, error) {
+	r, err := addr.Compile(re)
+	if err != nil {
+		return nil, nil, Range{}, err
+	}
+	b, err := w.Body()
+	if err != nil {
+		return nil, nil, Range{}, err
+	}
+	body := []rune(string(b))
+	lim := Range{Q0: 0, Q1: len(body)}
+	w.mu.Lock()
+	if w.limit != nil {
+		lim = *w.limit
+	}
+	w.mu.Unlock()
+	if lim.Q1 > len(body) {
+		lim.Q1 = len(body)
+	}
+	if lim.Q0 > lim.Q1 {
+		lim.Q0 = lim.Q1
+	}
+	return r, body, lim, nil
 }
 
 // SetData is used in conjunction with addr for random access to the
@@ -409,35 +734,241 @@ func (w *Win) SetData(data []byte) error {
 // that CurrentAddr() or similar has been called to properly set the addr
 // and retrieve valid q0 and q1 points.
 func (w *Win) Data(q0, q1 int) ([]byte, error) {
+	r, err := w.readRunes(q0, q1)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(string(r)), nil
+}
+
+// RuneRange sets the addr to the runes between q0 and q1 of the body
+// and reads them
+func (w *Win) RuneRange(q0, q1 int) ([]rune, error) {
+	if err := w.SetAddr("#%d,#%d", q0, q1); err != nil {
+		return nil, err
+	}
+	return w.readRunes(q0, q1)
+}
+
+// readRunes reads the q1-q0 runes of the body at addr. The addr
+// counts runes while reads count bytes, so xdata is read until enough
+// runes have been decoded.
+func (w *Win) readRunes(q0, q1 int) ([]rune, error) {
 	n := q1 - q0
 	if n <= 0 {
 		return nil, fmt.Errorf("invalid range %d,%d", q0, q1)
 	}
-	buf := make([]byte, n)
-	n2, err := w.w.Read("data", buf)
-	if err != nil {
-		return buf, err
+	var r []rune
+	buf := make([]byte, 8192)
+	for len(r) < n {
+		m, err := w.read("xdata", buf)
+		if err == io.EOF || m == 0 {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, []rune(string(buf[:m]))...)
 	}
-	if n2 != n {
-		return buf, fmt.Errorf("read %d bytes, expected %d", n2, n)
+	if len(r) < n {
+		return r, fmt.Errorf("read %d runes, expected %d", len(r), n)
 	}
-	return buf, nil
+	return r[:n], nil
 }
 
 // SetFont sets the font for the win
 func (w *Win) SetFont(font string) error {
-	return w.w.Ctl("font %s", font)
+	return w.Ctl("font %s", font)
 }
 
 // Font returns the font for the current win
 func (w *Win) Font() (tab int, font *draw.Font, err error) {
-	return w.w.Font()
+	ctl, err := w.readAll("ctl")
+	if err != nil {
+		return 0, nil, err
+	}
+	f := strings.Fields(string(ctl))
+	if len(f) < 8 {
+		return 0, nil, fmt.Errorf("malformed ctl file")
+	}
+	tab, _ = strconv.Atoi(f[7])
+	if tab == 0 {
+		return 0, nil, fmt.Errorf("malformed ctl file")
+	}
+	font, err = openFont(f[6])
+	return tab, font, err
+}
+
+// Tabwidth returns the width of a tab in the window in characters.
+// It is the width given to SetTabwidth, or else worked out from the
+// window's font.
+func (w *Win) Tabwidth() (int, error) {
+	w.mu.Lock()
+	tab := w.tab
+	w.mu.Unlock()
+	if tab > 0 {
+		return tab, nil
+	}
+	tab, font, err := w.Font()
+	if err != nil {
+		return 0, err
+	}
+	return tab / font.StringWidth("0"), nil
+}
+
+// SetTabwidth sets the width of a tab in the window to n characters
+func (w *Win) SetTabwidth(n int) error {
+	if err := w.Exec("Tab", strconv.Itoa(n)); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.tab = n
+	w.mu.Unlock()
+	return nil
+}
+
+// LineCol returns the line of the rune offset q and its visual column
+// on that line, accounting for the window's tab width. Lines and
+// columns count from 1.
+func (w *Win) LineCol(q int) (line, col int, err error) {
+	tab, err := w.Tabwidth()
+	if err != nil {
+		return 0, 0, err
+	}
+	var body []rune
+	if q > 0 {
+		if body, err = w.RuneRange(0, q); err != nil {
+			return 0, 0, err
+		}
+	}
+	line, col = addr.LineCol(body, q, tab)
+	return line, col, nil
+}
+
+// Offset returns the rune offset of the visual column col on the
+// line. Columns past the end of the line are moved to its end.
+func (w *Win) Offset(line, col int) (int, error) {
+	tab, err := w.Tabwidth()
+	if err != nil {
+		return 0, err
+	}
+	text, r, err := w.lines(line, line)
+	if err != nil {
+		return 0, err
+	}
+	return r.Q0 + addr.Column(text, col, tab), nil
+}
+
+// Line returns the text of line n, including its newline, and its
+// range in the body
+func (w *Win) Line(n int) ([]byte, Range, error) {
+	return w.Lines(n, n)
+}
+
+// Lines returns the text of the lines from and to, including the
+// newline of the last line, and their range in the body
+func (w *Win) Lines(from, to int) ([]byte, Range, error) {
+	text, r, err := w.lines(from, to)
+	if err != nil {
+		return nil, Range{}, err
+	}
+	return []byte(string(text)), r, nil
+}
+
+func (w *Win) lines(from, to int) ([]rune, Range, error) {
+	if from < 1 || to < from {
+		return nil, Range{}, addr.ErrRange
+	}
+	if err := w.SetAddr("%d,%d", from, to); err != nil {
+		return nil, Range{}, err
+	}
+	q0, q1, err := w.Addr()
+	if err != nil {
+		return nil, Range{}, err
+	}
+	r := Range{Q0: q0, Q1: q1}
+	if q1 == q0 {
+		return nil, r, nil
+	}
+	text, err := w.readRunes(q0, q1)
+	return text, r, err
+}
+
+// fonts caches the fonts opened by Font
+var fonts struct {
+	sync.Mutex
+	m map[string]*draw.Font
+}
+
+func openFont(name string) (*draw.Font, error) {
+	fonts.Lock()
+	defer fonts.Unlock()
+	if font, ok := fonts.m[name]; ok {
+		return font, nil
+	}
+	var disp *draw.Display
+	font, err := disp.OpenFont(name)
+	if err != nil {
+		return nil, err
+	}
+	if fonts.m == nil {
+		fonts.m = make(map[string]*draw.Font)
+	}
+	fonts.m[name] = font
+	return font, nil
+}
+
+// fid returns the open file in the window, opening it if needed
+func (w *Win) fid(file string) (*client.Fid, error) {
+	if w == nil || w.c == nil {
+		return nil, fmt.Errorf("window handle lost")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if f, ok := w.fids[file]; ok {
+		return f, nil
+	}
+	var mode uint8 = plan9.ORDWR
+	if file == "errors" {
+		mode = plan9.OWRITE
+	}
+	f, err := w.c.open(fmt.Sprintf("%d/%s", w.id, file), mode)
+	if err != nil {
+		return nil, err
+	}
+	w.fids[file] = f
+	return f, nil
+}
+
+func (w *Win) read(file string, data []byte) (int, error) {
+	f, err := w.fid(file)
+	if err != nil {
+		return 0, err
+	}
+	return f.Read(data)
+}
+
+// readAll reads the file from its start. The fids of a Win are shared
+// by every goroutine using it, so the seek and the reads are done
+// under a lock.
+func (w *Win) readAll(file string) ([]byte, error) {
+	f, err := w.fid(file)
+	if err != nil {
+		return nil, err
+	}
+	w.reads.Lock()
+	defer w.reads.Unlock()
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
 }
 
 func (w *Win) write(file string, data []byte) error {
-	if w == nil || w.w == nil {
-		return fmt.Errorf("window handle lost")
+	f, err := w.fid(file)
+	if err != nil {
+		return err
 	}
-	_, err := w.w.Write(file, data)
+	_, err = f.Write(data)
 	return err
 }
@@ -0,0 +1,465 @@
+package nyne
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnjp/nyne/acmetest"
+)
+
+// eventWin opens the events of a new window in the fake acme
+func eventWin(t *testing.T, name, body string) (*acmetest.Window, *Win, <-chan Event) {
+	t.Helper()
+	fw := fsrv.NewWindow(name, body)
+	w, err := OpenWin(fw.ID(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() {
+		close(stop)
+		w.Close()
+	})
+	events, _ := w.EventChan(stop)
+	if err := fw.WaitEventOpen(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return fw, w, events
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestEventChanChordedArg(t *testing.T) {
+	defer fsrv.Reset()
+	fw, _, events := eventWin(t, "/tmp/chord.txt", "hello world\n")
+
+	fw.ExecArg("Look", 6, 11)
+	e := nextEvent(t, events)
+	if e.Action != B2Tag || e.Text != "Look" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if string(e.ChordArg) != "world" {
+		t.Fatalf("expected chorded argument %q, got %q", "world", e.ChordArg)
+	}
+	expected := Location{File: "/tmp/chord.txt", Q0: 6, Q1: 11}
+	if e.ChordOrigin != expected {
+		t.Fatalf("expected origin %v, got %v", expected, e.ChordOrigin)
+	}
+
+	fw.Type("!")
+	if e := nextEvent(t, events); e.Origin != Keyboard || e.Text != "!" {
+		t.Fatalf("expected the next message to be a new event, got %+v", e)
+	}
+}
+
+func TestEventChanExpansion(t *testing.T) {
+	defer fsrv.Reset()
+	fw, _, events := eventWin(t, "/tmp/expand.txt", "run make now\n")
+
+	fw.ExecAt(6)
+	e := nextEvent(t, events)
+	if e.Action != B2Body || e.Text != "make" {
+		t.Fatalf("expected the expanded command, got %+v", e)
+	}
+	if e.SelBegin != 4 || e.SelEnd != 8 || e.OrigSelBegin != 6 || e.OrigSelEnd != 6 {
+		t.Fatalf("unexpected addresses %+v", e)
+	}
+}
+
+func TestEventChanLongText(t *testing.T) {
+	defer fsrv.Reset()
+	long := strings.Repeat("x", 300)
+	fw, _, events := eventWin(t, "/tmp/long.txt", long+"\n")
+
+	fw.Look(0, 300)
+	e := nextEvent(t, events)
+	if e.Text.String() != long || e.NumRunes != 300 {
+		t.Fatalf("expected the text to be read from the body, got %d runes", e.NumRunes)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/transaction.txt", "one\n")
+	w, err := OpenWin(fw.ID(), "/tmp/transaction.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.AppendBody([]byte("two\n")); err != nil {
+		t.Fatal(err)
+	}
+	err = w.Transaction(func(w *Win) error {
+		for _, text := range []string{"three\n", "four\n"} {
+			if err := w.AppendBody([]byte(text)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Exec("Undo")
+	if fw.Body() != "one\ntwo\n" {
+		t.Fatalf("expected a single undo to revert the transaction, got %q", fw.Body())
+	}
+
+	// marking is restored when the transaction fails
+	expected := errors.New("failed")
+	err = w.Transaction(func(w *Win) error {
+		if err := w.AppendBody([]byte("five\n")); err != nil {
+			return err
+		}
+		return expected
+	})
+	if err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+	if err := w.AppendBody([]byte("six\n")); err != nil {
+		t.Fatal(err)
+	}
+	fw.Exec("Undo")
+	if fw.Body() != "one\ntwo\nfive\n" {
+		t.Fatalf("expected undo to only revert the last edit, got %q", fw.Body())
+	}
+}
+
+func TestExec(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/exec/", "")
+	w, err := OpenWin(fw.ID(), "/tmp/exec/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.AppendTag("|com mine"); err != nil {
+		t.Fatal(err)
+	}
+	tag := fw.Tag()
+
+	if err := w.Exec("Tab", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if fw.Tabwidth() != 3 {
+		t.Fatalf("expected tab width 3, got %d", fw.Tabwidth())
+	}
+	if err := w.Exec("Look"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Exec("Get"); err != nil {
+		t.Fatal(err)
+	}
+	if fw.Tag() != tag {
+		t.Fatalf("expected tag %q, got %q", tag, fw.Tag())
+	}
+	expected := []string{"Tab 3", "Look"}
+	if got := fw.Executed(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q to be executed, got %q", expected, got)
+	}
+	if fw.EventOpen() {
+		t.Fatal("expected the event file to be closed")
+	}
+
+	// text typed in the tag while the command ran is kept
+	before, err := w.Tag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, added, err := w.addCmd(before, "Tab 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AppendTag(" typed"); err != nil {
+		t.Fatal(err)
+	}
+	w.removeCmd(ParseTag(before), ParseTag(added))
+	if expected := tag + " Tab 4 typed"; fw.Tag() != expected {
+		t.Fatalf("expected tag %q, got %q", expected, fw.Tag())
+	}
+}
+
+func TestExecExternal(t *testing.T) {
+	defer fsrv.Reset()
+	dir, err := ioutil.TempDir("", "nyne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "exec.txt")
+	fw := fsrv.NewWindow(name, "")
+	w, err := OpenWin(fw.ID(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	tag := fw.Tag()
+
+	if err := w.Exec("echo", "$winid", "$samfile", "`pwd`"); err != nil {
+		t.Fatal(err)
+	}
+	ew := fsrv.Lookup(filepath.Join(dir, "+Errors"))
+	if ew == nil {
+		t.Fatal("expected the output in +Errors")
+	}
+	expected := fmt.Sprintf("%d %s %s\n", fw.ID(), name, dir)
+	if ew.Body() != expected {
+		t.Fatalf("expected output %q, got %q", expected, ew.Body())
+	}
+	if fw.Tag() != tag || len(fw.Executed()) != 0 {
+		t.Fatalf("expected the tag to be left alone, got %q", fw.Tag())
+	}
+
+	err = w.Exec("exit", "3")
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+}
+
+func TestWinConcurrent(t *testing.T) {
+	defer fsrv.Reset()
+	body := strings.Repeat("x", 1000)
+	fw := fsrv.NewWindow("/tmp/concurrent.txt", body)
+	w, err := OpenWin(fw.ID(), "/tmp/concurrent.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				b, err := w.Body()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(b) != len(body) {
+					errs <- fmt.Errorf("read %d bytes of %d", len(b), len(body))
+					return
+				}
+			}
+		}()
+	}
+	for _, e := range []struct {
+		q    int
+		text string
+	}{{10, "A"}, {900, "B"}} {
+		wg.Add(1)
+		go func(q int, text string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				err := Transaction(w, func(w Window) error {
+					if err := w.SetAddr("#%d,#%d", q, q+1); err != nil {
+						return err
+					}
+					return w.SetData([]byte(text))
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(e.q, e.text)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	expected := body[:10] + "A" + body[11:900] + "B" + body[901:]
+	if fw.Body() != expected {
+		t.Fatalf("expected the edits to stay in their ranges, got %d runes", len(fw.Body()))
+	}
+}
+
+func TestFindCmd(t *testing.T) {
+	tag := []rune("a.txt Del | Looks Look é")
+	testCases := []struct {
+		cmd    string
+		q0, q1 int
+		ok     bool
+	}{
+		{"Look", 18, 22, true},
+		{"é", 23, 24, true},
+		{"Del", 6, 9, true},
+		{"Lo", 0, 0, false},
+		{"Put", 0, 0, false},
+	}
+	for _, tc := range testCases {
+		q0, q1, ok := findCmd(tag, tc.cmd)
+		if q0 != tc.q0 || q1 != tc.q1 || ok != tc.ok {
+			t.Fatalf("%s: expected %d,%d %v, got %d,%d %v",
+				tc.cmd, tc.q0, tc.q1, tc.ok, q0, q1, ok)
+		}
+	}
+}
+
+func TestRuneRange(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/runes.txt", "aé😀b\nçd\n")
+	w, err := OpenWin(fw.ID(), "/tmp/runes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, err := w.RuneRange(1, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r) != "é😀b\nç" {
+		t.Fatalf("expected %q, got %q", "é😀b\nç", string(r))
+	}
+	if err := w.SetAddr("#2,#4"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := w.Data(2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "😀b" {
+		t.Fatalf("expected %q, got %q", "😀b", data)
+	}
+	c, err := w.Char(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != 'ç' {
+		t.Fatalf("expected %q, got %q", 'ç', c)
+	}
+}
+
+func TestFind(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/find.txt", "TODO: ä\nnothing\nTODO: ö\n")
+	w, err := OpenWin(fw.ID(), "/tmp/find.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	all, err := w.Find("^TODO: .$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Range{{Q0: 0, Q1: 7}, {Q0: 16, Q1: 23}}
+	if !reflect.DeepEqual(all, expected) {
+		t.Fatalf("expected %v, got %v", expected, all)
+	}
+
+	testCases := []struct {
+		name     string
+		find     func(string, int) (Range, error)
+		q        int
+		expected Range
+	}{
+		{"next", w.FindNext, 1, Range{Q0: 16, Q1: 20}},
+		{"next wraps", w.FindNext, 17, Range{Q0: 0, Q1: 4}},
+		{"prev", w.FindPrev, 16, Range{Q0: 0, Q1: 4}},
+		{"prev wraps", w.FindPrev, 3, Range{Q0: 16, Q1: 20}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := tc.find("TODO", tc.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, r)
+			}
+		})
+	}
+
+	if _, err := w.FindNext("missing", 0); err == nil {
+		t.Fatal("expected no match")
+	}
+
+	// searches stay within the limit
+	if err := w.SetAddr("2,$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.LimitSearchToAddr(); err != nil {
+		t.Fatal(err)
+	}
+	all, err = w.Find("TODO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Range{{Q0: 16, Q1: 20}}; !reflect.DeepEqual(all, expected) {
+		t.Fatalf("expected %v, got %v", expected, all)
+	}
+}
+
+func TestLineCol(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/linecol.txt", "one\n\ttwö\nthree\n")
+	w, err := OpenWin(fw.ID(), "/tmp/linecol.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.SetTabwidth(4); err != nil {
+		t.Fatal(err)
+	}
+	if fw.Tabwidth() != 4 {
+		t.Fatalf("expected acme's tab width to be 4, got %d", fw.Tabwidth())
+	}
+
+	line, col, err := w.LineCol(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != 2 || col != 7 {
+		t.Fatalf("expected 2:7, got %d:%d", line, col)
+	}
+	q, err := w.Offset(3, col)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q != 14 {
+		t.Fatalf("expected 3:7 to be clamped to #14, got #%d", q)
+	}
+
+	text, r, err := w.Line(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "\ttwö\n" || r != (Range{Q0: 4, Q1: 9}) {
+		t.Fatalf("unexpected line %q at %v", text, r)
+	}
+	text, r, err = w.Lines(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "one\n\ttwö\n" || r != (Range{Q0: 0, Q1: 9}) {
+		t.Fatalf("unexpected lines %q at %v", text, r)
+	}
+	if _, _, err := w.Line(7); err == nil {
+		t.Fatal("expected line 7 to be out of range")
+	}
+}
@@ -0,0 +1,111 @@
+package nyne
+
+import "github.com/dnjp/nyne/addr"
+
+// Window is an acme window that nyne can read, edit and receive
+// events from. Win implements Window using the acme file system,
+// but hooks written against Window can be run against edwood or a
+// test double as well.
+type Window interface {
+	// ID returns the acme window ID
+	ID() int
+	// File returns the name of the file in the window
+	File() string
+
+	// EventChan opens a channel to window events. Events are no
+	// longer read once stop is closed.
+	EventChan(stop <-chan struct{}) (<-chan Event, <-chan error)
+	// WriteEvent writes the event back to acme
+	WriteEvent(e Event) error
+	// Ctl writes a message to the window's ctl file
+	Ctl(format string, args ...interface{}) error
+	// Exec executes the given command in the window tag
+	Exec(exec string, args ...string) error
+	// Show guarantees at least some of the selected text is visible
+	Show() error
+	// Close closes down the window with associated files
+	Close()
+	// Lock locks the window for a sequence of edits that depend on
+	// each other, such as setting addr and then writing data, and
+	// Unlock unlocks it. Hooks run on more than one goroutine, so
+	// such sequences are made under the lock.
+	Lock()
+	Unlock()
+
+	// Addr returns the current address of the window
+	Addr() (q0, q1 int, err error)
+	// SetAddr sets the address of the window
+	SetAddr(fmtstr string, args ...interface{}) error
+	// CurrentAddr sets the addr to dot and reads the addr
+	CurrentAddr() (q0, q1 int, err error)
+	// AddrFromSelection sets addr to dot
+	AddrFromSelection() error
+	// SelectionFromAddr sets dot to addr
+	SelectionFromAddr() error
+
+	// Data reads the body between q0 and q1 starting at addr
+	Data(q0, q1 int) ([]byte, error)
+	// RuneRange reads the runes of the body between q0 and q1
+	RuneRange(q0, q1 int) ([]rune, error)
+	// SetData replaces the text at addr
+	SetData(data []byte) error
+	// Body returns the window body
+	Body() ([]byte, error)
+	// AppendBody appends the given text to the body
+	AppendBody(data []byte) error
+	// ClearBody clears the text from the body
+	ClearBody() error
+
+	// Tag returns the tag contents
+	Tag() ([]byte, error)
+	// AppendTag writes to the window's tag
+	AppendTag(text string) error
+	// ClearTag removes all text in the tag after the vertical bar
+	ClearTag() error
+}
+
+// Range is a range of runes in a window body
+type Range = addr.Range
+
+// WinOpener opens the Window with the given ID and file name
+type WinOpener func(id int, file string) (Window, error)
+
+// OpenWindow is the default WinOpener and opens the window in acme
+func OpenWindow(id int, file string) (Window, error) {
+	w, err := OpenWin(id, file)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+var _ Window = (*Win)(nil)
+
+// Transaction is like Win.Transaction but groups the edits fn makes to
+// any Window using its ctl file. The window is locked while fn runs.
+func Transaction(w Window, fn func(Window) error) error {
+	w.Lock()
+	defer w.Unlock()
+	mark := func() error { return w.Ctl("mark") }
+	nomark := func() error { return w.Ctl("nomark") }
+	return transaction(mark, nomark, func() error {
+		return fn(w)
+	})
+}
+
+// transaction marks the edits made before fn so they are undone
+// separately, then runs fn without marking
+func transaction(mark, nomark, fn func() error) (err error) {
+	if err := mark(); err != nil {
+		return err
+	}
+	if err := nomark(); err != nil {
+		return err
+	}
+	defer func() {
+		if merr := mark(); err == nil {
+			err = merr
+		}
+	}()
+	return fn()
+}
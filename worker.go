@@ -0,0 +1,96 @@
+package nyne
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultHookTimeout is how long a Buf waits for a write hook before
+// reporting it as timed out and moving on to the next one
+const DefaultHookTimeout = time.Minute
+
+// Pool bounds the number of write hooks that run at once across every
+// window
+type Pool struct {
+	sem chan struct{}
+}
+
+// NewPool constructs a Pool that runs at most n hooks at once
+func NewPool(n int) *Pool {
+	if n < 1 {
+		n = 1
+	}
+	return &Pool{sem: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot in the pool or for ctx to be done
+func (p *Pool) acquire(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) release() {
+	if p == nil {
+		return
+	}
+	<-p.sem
+}
+
+// queue runs jobs one at a time in the order they were pushed. Pushing
+// never blocks, so the event loop of a window is never held up by the
+// jobs it queues.
+type queue struct {
+	mu   sync.Mutex
+	jobs []func()
+	wake chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{wake: make(chan struct{}, 1)}
+}
+
+// push adds the job to the end of the queue
+func (q *queue) push(job func()) {
+	q.mu.Lock()
+	q.jobs = append(q.jobs, job)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run runs the jobs until ctx is done. Jobs still queued then are
+// dropped.
+func (q *queue) run(ctx context.Context) {
+	for {
+		q.mu.Lock()
+		var job func()
+		if len(q.jobs) > 0 {
+			job = q.jobs[0]
+			q.jobs[0] = nil
+			q.jobs = q.jobs[1:]
+		}
+		q.mu.Unlock()
+		if job != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			job()
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
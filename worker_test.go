@@ -0,0 +1,261 @@
+package nyne
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := newQueue()
+	go q.run(ctx)
+
+	var ran []int
+	done := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		i := i
+		q.push(func() {
+			ran = append(ran, i)
+			if i == 99 {
+				close(done)
+			}
+		})
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("jobs were not run")
+	}
+	for i, n := range ran {
+		if i != n {
+			t.Fatalf("expected jobs to run in order, got %v", ran)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	p := NewPool(2)
+	ctx := context.Background()
+	var (
+		mu       sync.Mutex
+		cur, max int
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.acquire(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+			defer p.release()
+			mu.Lock()
+			cur++
+			if cur > max {
+				max = cur
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			cur--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if max > 2 {
+		t.Fatalf("expected at most 2 hooks at once, got %d", max)
+	}
+
+	// a full pool gives up when the context is done
+	p = NewPool(1)
+	p.acquire(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := p.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestBufWriteHooksAsync(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/async.txt", "")
+
+	b := NewBuf(fw.ID(), "/tmp/async.txt")
+	b.HookTimeout = 100 * time.Millisecond
+	errs := make(chan *HookError, 1)
+	b.Errors = func(err *HookError) { errs <- err }
+
+	release := make(chan struct{})
+	late := make(chan struct{})
+	var (
+		mu  sync.Mutex
+		ran []string
+	)
+	hook := func(name string, wait bool) Hook {
+		return func(e Event) error {
+			if wait {
+				<-release
+			}
+			mu.Lock()
+			ran = append(ran, name)
+			mu.Unlock()
+			return nil
+		}
+	}
+	b.EventHooks["Slow"] = []Handler{
+		func(e Event) (Event, bool) {
+			e.WriteHooks = append(e.WriteHooks, hook("slow", true))
+			return e, true
+		},
+	}
+	b.EventHooks["Stuck"] = []Handler{
+		func(e Event) (Event, bool) {
+			e.WriteHooks = append(e.WriteHooks, func(e Event) error {
+				<-e.Context().Done()
+				<-late
+				return hook("stuck", false)(e)
+			}, func(e Event) error {
+				defer close(late)
+				return hook("after", false)(e)
+			})
+			return e, true
+		},
+	}
+	typed := make(chan struct{}, 1)
+	b.KeyHooks['x'] = func(e Event) (Event, bool) {
+		typed <- struct{}{}
+		return e, true
+	}
+	go b.Start()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// typing is handled while a write hook runs
+	fw.Exec("Slow")
+	fw.Type("x")
+	select {
+	case <-typed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("key hook waited for the write hook")
+	}
+
+	// hooks of later events wait for the earlier ones, and a hook
+	// that times out is reported and cancelled, and the next one runs
+	// without waiting for it
+	fw.Exec("Stuck")
+	close(release)
+	select {
+	case err := <-errs:
+		if err.Hook != "hook 0 write" || err.ID != fw.ID() {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook did not time out")
+	}
+	// the late hook finishes after the next one has run
+	waitFor(t, "hooks", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return reflect.DeepEqual(ran, []string{"slow", "after", "stuck"})
+	})
+}
+
+func TestBufHookTimeoutReleasesPool(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/pool.txt", "")
+
+	b := NewBuf(fw.ID(), "/tmp/pool.txt")
+	b.Pool = NewPool(1)
+	b.HookTimeout = 50 * time.Millisecond
+	b.Errors = func(*HookError) {}
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+	ran := make(chan struct{}, 1)
+	b.EventHooks["Stuck"] = []Handler{
+		func(e Event) (Event, bool) {
+			// ignores its context
+			e.WriteHooks = append(e.WriteHooks, func(Event) error {
+				<-stuck
+				return nil
+			})
+			return e, true
+		},
+	}
+	b.EventHooks["Next"] = []Handler{
+		func(e Event) (Event, bool) {
+			e.WriteHooks = append(e.WriteHooks, func(Event) error {
+				ran <- struct{}{}
+				return nil
+			})
+			return e, true
+		},
+	}
+	go b.Start()
+	if err := fw.WaitEventOpen(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.Exec("Stuck")
+	fw.Exec("Next")
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hook that timed out kept its slot of the pool")
+	}
+}
+
+func TestBufWinHookTimeout(t *testing.T) {
+	defer fsrv.Reset()
+	fw := fsrv.NewWindow("/tmp/winhook.txt", "")
+
+	b := NewBuf(fw.ID(), "/tmp/winhook.txt")
+	b.HookTimeout = 50 * time.Millisecond
+	errs := make(chan *HookError, 1)
+	b.Errors = func(err *HookError) { errs <- err }
+
+	release := make(chan struct{})
+	late := make(chan error, 1)
+	ran := make(chan struct{}, 1)
+	b.WinHooks[New] = []WinHandler{
+		func(w Window) {
+			<-release
+			late <- w.AppendTag(" Late")
+		},
+		func(w Window) {
+			ran <- struct{}{}
+		},
+	}
+	go b.Start()
+
+	select {
+	case err := <-errs:
+		if err.Hook != "hook 0" || err.ID != fw.ID() {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("window hook did not time out")
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the next window hook waited for the one that timed out")
+	}
+
+	// the late hook can no longer edit the window
+	close(release)
+	if err := <-late; err == nil {
+		t.Fatal("expected the late edit to fail")
+	}
+	if strings.Contains(fw.Tag(), "Late") {
+		t.Fatalf("expected the late edit to be dropped, got tag %q", fw.Tag())
+	}
+}